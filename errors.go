@@ -172,13 +172,11 @@ func HereSkipping(err error, skip int) Error {
 	return WrapSkipping(err, skip+1, v2.CaptureStack(false))
 }
 
-// Message returns just returns err.Error().  It is here for
-// historical reasons.
+// Message returns the error's bare message: the message set with WithMessage, or otherwise
+// the message of the wrapped error.  Unlike err.Error(), it never includes the details or
+// causes appended to the message by verbose errors.  See v2.Message.
 func Message(err error) string {
-	if err == nil {
-		return ""
-	}
-	return err.Error()
+	return v2.Message(err)
 }
 
 // Stack returns the stack attached to an error, or nil if one is not attached
@@ -281,6 +281,26 @@ func TestIs(t *testing.T) {
 	for _, tst := range nilTests {
 		assert.Equal(t, tst.expect, Is(tst.arg1, tst.arg2), tst.msg)
 	}
+
+	// non-comparable errors never match, like errors.Is, but don't panic
+	nc := nonComparableError{msgs: []string{"blag"}}
+	assert.NotPanics(t, func() {
+		assert.False(t, Is(nc, nc))
+		assert.False(t, Is(Here(nc), nc))
+		assert.False(t, Is(WithCause(New("t"), nc), nc))
+		assert.False(t, Is(nc, ParseError))
+		assert.True(t, Is(WithCause(nc, ParseError), ParseError))
+		assert.True(t, Is(Wrap(nc).WithCause(ParseError), nc, ParseError))
+	})
+}
+
+// nonComparableError panics if compared with ==.
+type nonComparableError struct {
+	msgs []string
+}
+
+func (e nonComparableError) Error() string {
+	return strings.Join(e.msgs, ", ")
 }
 
 func TestHTTPCode(t *testing.T) {
@@ -374,6 +394,20 @@ func TestMessage(t *testing.T) {
 	// when error is nil, return ""
 	assert.Empty(t, Message(nil))
 
+	// causes appended to the message aren't included
+	var err error = WithCause(New("one"), errors.New("two"))
+	assert.Equal(t, "one", Message(err))
+	err = v2.Wrap(New("one"), v2.WithCause(errors.New("two")), v2.AlwaysVerbose())
+	assert.Contains(t, err.Error(), "Caused By: two")
+	assert.Equal(t, "one", Message(err))
+	err = v2.Wrap(Prepend(errors.New("two"), "one"), v2.WithHTTPCode(404), v2.AlwaysVerbose())
+	assert.NotEqual(t, "one: two", err.Error())
+	assert.Equal(t, "one: two", Message(err))
+
+	// messages of other error packages are returned as is
+	err = v2.Wrap(fmt.Errorf("one: %w", New("two")), v2.AlwaysVerbose())
+	assert.Equal(t, "one: two", Message(err))
+
 }
 
 func TestWithUserMessage(t *testing.T) {
@@ -383,6 +417,11 @@ func TestWithUserMessage(t *testing.T) {
 	assert.Equal(t, "a glitch", UserMessage(e))
 	e = WithUserMessagef(e, "not a %s deal", "huge")
 	assert.Equal(t, "not a huge deal", UserMessage(e))
+
+	// if the message is empty, the user message is used
+	e = WithUserMessage(New(""), "a glitch")
+	assert.Equal(t, "a glitch", e.Error())
+	assert.Equal(t, "a glitch", UserMessage(e))
 }
 
 func TestAppend(t *testing.T) {
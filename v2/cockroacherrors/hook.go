@@ -0,0 +1,109 @@
+// Package cockroacherrors provides merry hooks to integrate github.com/cockroachdb/errors
+// with merry.  The hooks detect errors created with github.com/cockroachdb/errors, and
+// translate their stacks into merry stacks, their hints into merry user messages, and
+// their safe details into a registered merry detail.
+//
+// This package is a separate module, so only programs which use it depend on
+// github.com/cockroachdb/errors.
+package cockroacherrors
+
+import (
+	errors2 "errors"
+	"strings"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/errbase"
+)
+
+type errKey int
+
+const errKeySafeDetails errKey = iota
+
+func init() {
+	merry.RegisterDetail("Safe Details", errKeySafeDetails)
+}
+
+// Install installs IntegrateStacks() and IntegrateSafeDetails() as merry hooks.
+func Install() {
+	merry.AddHooks(IntegrateStacks(), IntegrateSafeDetails())
+}
+
+type stackTracer interface {
+	StackTrace() errbase.StackTrace
+}
+
+// IntegrateStacks searches the error chain for errors created by
+// github.com/cockroachdb/errors, which have a stack attached.  The stack
+// is attached to the merry error.
+func IntegrateStacks() merry.Wrapper {
+	return merry.WrapperFunc(func(err error, depth int) error {
+		var s stackTracer
+
+		if err != nil && !merry.HasStack(err) && errors2.As(err, &s) {
+			if frames := s.StackTrace(); len(frames) > 0 {
+				stack := make([]uintptr, len(frames))
+				for i := range frames {
+					stack[i] = uintptr(frames[i])
+				}
+				return merry.WithStack(stack).Wrap(err, depth)
+			}
+		}
+
+		return err
+	})
+}
+
+// IntegrateSafeDetails copies the hints and safe details attached by
+// github.com/cockroachdb/errors into merry.
+//
+// If the error has no merry user message, the cockroach hints are joined
+// and attached with merry.WithUserMessage.  The safe details of each
+// layer in the chain, other than the stack traces, are collected and attached
+// to the error, where they can be read with SafeDetails(), and are printed
+// by merry.Details() under the label "Safe Details".
+func IntegrateSafeDetails() merry.Wrapper {
+	return merry.WrapperFunc(func(err error, depth int) error {
+		if err == nil || merry.Value(err, errKeySafeDetails) != nil {
+			return err
+		}
+
+		if merry.UserMessage(err) == "" {
+			if hint := errors.FlattenHints(err); hint != "" {
+				err = merry.WithUserMessage(hint).Wrap(err, depth)
+			}
+		}
+
+		if details := safeDetails(err); len(details) > 0 {
+			err = merry.WithValue(errKeySafeDetails, details).Wrap(err, depth)
+		}
+
+		return err
+	})
+}
+
+// SafeDetails returns the safe details copied from a cockroach error by
+// IntegrateSafeDetails, outermost first.  Returns nil if there are none.
+func SafeDetails(err error) []string {
+	details, _ := merry.Value(err, errKeySafeDetails).([]string)
+	return details
+}
+
+func safeDetails(err error) []string {
+	var details []string
+
+	for ; err != nil; err = errors2.Unwrap(err) {
+		if _, ok := err.(stackTracer); ok {
+			// the stack is integrated by IntegrateStacks
+			continue
+		}
+
+		for _, d := range errors.GetSafeDetails(err).SafeDetails {
+			if d = strings.TrimSpace(d); d != "" {
+				details = append(details, d)
+			}
+		}
+	}
+
+	return details
+}
@@ -0,0 +1,51 @@
+package cockroacherrors
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHook(t *testing.T) {
+	merry.ClearHooks()
+	Install()
+
+	var err error
+
+	_, _, rl, _ := runtime.Caller(0)
+	err = errors.New("crash")
+	err = merry.Wrap(err, merry.WithMessage("yikes"))
+
+	assert.EqualError(t, err, "yikes")
+	file, line := merry.Location(err)
+
+	assert.Contains(t, file, "hook_test.go")
+	assert.Equal(t, rl+1, line)
+}
+
+func TestIntegrateSafeDetails(t *testing.T) {
+	merry.ClearHooks()
+	Install()
+
+	err := errors.Newf("boom %s", errors.Safe("safe"))
+	err = errors.WithHint(err, "try again")
+	err = errors.WithSafeDetails(err, "id=%d", 7)
+	err = merry.Wrap(err)
+
+	assert.Equal(t, "try again", merry.UserMessage(err))
+	assert.Equal(t, []string{"id=×", "boom safe"}, SafeDetails(err))
+	assert.Contains(t, merry.Details(err), "Safe Details: [id=× boom safe]")
+
+	// the hook doesn't attach the details twice, or override an existing user message
+	err = merry.Wrap(err, merry.WithUserMessage("sorry"))
+	assert.Equal(t, "sorry", merry.UserMessage(err))
+	assert.Equal(t, []string{"id=×", "boom safe"}, SafeDetails(err))
+
+	// errors without cockroach details are left alone
+	err = merry.New("plain")
+	assert.Empty(t, merry.UserMessage(err))
+	assert.Nil(t, SafeDetails(err))
+}
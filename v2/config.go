@@ -1,40 +1,296 @@
 package merry
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"reflect"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-var maxStackDepth = 50
-var captureStacks = true
+// The global settings are accessed atomically, so they can be changed safely while
+// errors are being created on other goroutines.  Booleans are stored as 0 or 1.
+var maxStackDepth int32 = 50
+var captureStacks int32 = 1
+var maxMessageLength int32
+var maxCauseDepth int32
+var userMessageLocalizer atomic.Value // localizer
+var frameFormatter atomic.Value       // frameFormatterFunc
+var httpCodeDefaulting int32 = 1
+var debugChecks int32
+
+type localizer struct {
+	fn func(key, locale string) string
+}
+
+type frameFormatterFunc struct {
+	fn func(frame Frame) string
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
 
 // StackCaptureEnabled returns whether stack capturing is enabled.
 func StackCaptureEnabled() bool {
-	return captureStacks
+	return atomic.LoadInt32(&captureStacks) == 1
 }
 
 // SetStackCaptureEnabled sets stack capturing globally.  Disabling stack capture can increase performance.
 // Capture can be forced or suppressed to override this global setting on a particular error.
 func SetStackCaptureEnabled(enabled bool) {
-	captureStacks = enabled
+	atomic.StoreInt32(&captureStacks, boolToInt32(enabled))
 }
 
 // MaxStackDepth returns the number of frames captured in stacks.
 func MaxStackDepth() int {
-	return maxStackDepth
+	return int(atomic.LoadInt32(&maxStackDepth))
 }
 
-// SetMaxStackDepth sets the MaxStackDepth.
+// SetMaxStackDepth sets the MaxStackDepth.  The default is 50.  Capturing stacks is the most
+// expensive part of creating an error, and the cost grows with the depth, so applications which
+// only look at the top few frames can set a smaller depth, like 16, when they are initialized.
+// See also QuickStack.
 func SetMaxStackDepth(depth int) {
-	maxStackDepth = depth
+	atomic.StoreInt32(&maxStackDepth, int32(depth))
+}
+
+// DebugChecks returns whether wrappers are checked for bugs as they are applied.
+func DebugChecks() bool {
+	return atomic.LoadInt32(&debugChecks) == 1
+}
+
+// SetDebugChecks enables checking that wrappers preserve the errors they wrap.  It is a
+// development aid for authors of custom Wrapper implementations.  When enabled, Wrap,
+// Apply, and related functions panic if a wrapper returns nil for a non-nil error, or
+// returns an error which doesn't match the error it wrapped with errors.Is(), e.g.
+// because it returned a new error instead of wrapping the old one.
+//
+// Checks are disabled by default.  They add overhead to applying each wrapper, so they
+// shouldn't be enabled in production.
+func SetDebugChecks(enabled bool) {
+	atomic.StoreInt32(&debugChecks, boolToInt32(enabled))
+}
+
+// HTTPCodeDefaulting returns whether HTTPCode() maps errors without an HTTP code to 500.
+func HTTPCodeDefaulting() bool {
+	return atomic.LoadInt32(&httpCodeDefaulting) == 1
+}
+
+// SetHTTPCodeDefaulting sets whether HTTPCode() maps errors without an HTTP code to 500.
+// The default is true.  If set to false, HTTPCode() returns the code attached to
+// the error, or 0 if there is none, leaving it to the edge of the application (e.g. an
+// HTTP handler) to decide on the default.
+func SetHTTPCodeDefaulting(enabled bool) {
+	atomic.StoreInt32(&httpCodeDefaulting, boolToInt32(enabled))
+}
+
+// MaxMessageLength returns the maximum length, in runes, of the messages returned by
+// Error().  0 means unlimited.
+func MaxMessageLength() int {
+	return int(atomic.LoadInt32(&maxMessageLength))
+}
+
+// SetMaxMessageLength sets the maximum length, in runes, of the messages returned by the
+// Error() method of errors produced by this package.  Longer messages are truncated, and
+// end with an ellipsis.  This guards against messages which grow very large, e.g. from
+// repeatedly prepending messages in a loop.  The default is 0, which means unlimited.
+func SetMaxMessageLength(length int) {
+	atomic.StoreInt32(&maxMessageLength, int32(length))
+}
+
+// MaxCauseDepth returns the maximum number of causes in an error's chain of causes.
+// 0 means unlimited.
+func MaxCauseDepth() int {
+	return int(atomic.LoadInt32(&maxCauseDepth))
+}
+
+// SetMaxCauseDepth sets the maximum number of causes in the chain of causes of errors
+// produced by WithCause.  When attaching a cause would make the chain longer, the oldest
+// causes, at the bottom of the chain, are dropped: errors.Is(), errors.As(), Cause(), and
+// Details() don't see them.  The last kept cause still matches itself with errors.Is().
+// Causes beneath errors created by other packages can't be dropped.  This guards against chains which grow
+// without bound, e.g. from a retry loop which attaches the previous attempt's error as the
+// cause of the next one.  The default is 0, which means unlimited.
+func SetMaxCauseDepth(depth int) {
+	atomic.StoreInt32(&maxCauseDepth, int32(depth))
+}
+
+// UserMessageLocalizer returns the function installed with SetUserMessageLocalizer, or nil.
+func UserMessageLocalizer() func(key, locale string) string {
+	l, _ := userMessageLocalizer.Load().(localizer)
+	return l.fn
+}
+
+// SetUserMessageLocalizer installs a function which translates user message keys into
+// localized messages.  It is called by LocalizedUserMessage with the message key attached
+// to the error with WithUserMessageKey, and a locale.  It should return an empty string if
+// the key can't be translated.  Decoupling the key from the translated text lets errors
+// be created once, and rendered for each user's language when they are returned.
+func SetUserMessageLocalizer(fn func(key, locale string) string) {
+	userMessageLocalizer.Store(localizer{fn: fn})
+}
+
+// Frame is a stack frame, as returned by runtime.CallersFrames.
+type Frame = runtime.Frame
+
+// FrameFormatter returns the function installed with SetFrameFormatter, or nil.
+func FrameFormatter() func(frame Frame) string {
+	f, _ := frameFormatter.Load().(frameFormatterFunc)
+	return f.fn
+}
+
+// SetFrameFormatter installs a function which formats each frame of the stacks rendered by
+// FormattedStack(), Stacktrace(), and Details(), to match the conventions of a logging system.
+// The default format, which is used if formatter is nil, is the function name, followed by
+// the file and line on the next line, indented with a tab:
+//
+//	github.com/ansel1/merry/v2.New
+//		/home/user/merry/errors.go:14
+func SetFrameFormatter(formatter func(frame Frame) string) {
+	frameFormatter.Store(frameFormatterFunc{fn: formatter})
 }
 
 func init() {
 	RegisterDetail("User Message", errKeyUserMessage)
-	RegisterDetail("HTTP Code", errKeyHTTPCode)
+	RegisterDetailFunc("HTTP Code", func(err error) interface{} {
+		if code := attachedHTTPCode(err); code != 0 {
+			return code
+		}
+		return nil
+	})
+	RegisterDetail("Locale", errKeyLocale)
+	RegisterDetail("User Message Key", errKeyUserMessageKey)
+	RegisterDetail("HTTP Headers", errKeyHTTPHeaders)
+	RegisterDetail("Layer", errKeyLayer)
+	RegisterDetail("Kind", errKeyKind)
+	RegisterDetail("Operation", errKeyOp)
+	RegisterDetail("Elapsed", errKeyElapsed)
+	RegisterDetail("Hint", errKeyHint)
+	RegisterDetail("Previous Message", errKeyPreviousMessage)
+	RegisterDetail("Deadline", errKeyDeadline)
+	RegisterDetail("Build", errKeyBuild)
+	RegisterDetail("Severity", errKeySeverity)
+	RegisterDetailFunc("Caller", func(err error) interface{} {
+		if caller := Caller(err); caller != "" {
+			return caller
+		}
+		return nil
+	})
+}
+
+var publicValuesLock sync.Mutex
+var publicValues = []interface{}{
+	errKeyUserMessage,
+	errKeyUserMessageKey,
+	errKeyLocale,
+	errKeyHTTPHeaders,
+	errKeyRetryAfter,
+	errKeyHint,
 }
 
-var detailsLock sync.Mutex
-var detailFields = map[string]func(err error) interface{}{}
+// RegisterPublicValue marks values attached with key as safe to return to clients, so
+// Client preserves them.  The user message, its key and locale, the HTTP headers, and
+// the retry delay are public by default.
+func RegisterPublicValue(key interface{}) {
+	publicValuesLock.Lock()
+	defer publicValuesLock.Unlock()
+
+	for _, k := range publicValues {
+		if k == key {
+			return
+		}
+	}
+	// copy on write, so Client can read the list without holding the lock
+	publicValues = append(publicValues[:len(publicValues):len(publicValues)], key)
+}
+
+func publicValueKeys() []interface{} {
+	publicValuesLock.Lock()
+	defer publicValuesLock.Unlock()
+
+	return publicValues
+}
+
+var wrapperPackagesLock sync.Mutex
+var wrapperPackages []string
+
+// wrapperFrames is how many extra frames are captured when wrapper packages are registered.
+const wrapperFrames = 16
+
+// RegisterWrapperPackage registers the packages under a package path prefix as wrapper
+// packages: packages which wrap this package in helpers, so errors are created or wrapped
+// several calls away from the application code which called the helper.  When stacks are
+// captured, leading frames from wrapper packages are dropped, so the stacks start at the
+// application code, and Location() points there, without adjusting the skip counts passed
+// to WrapSkipping for the depth of the helpers:
+//
+//	merry.RegisterWrapperPackage("myapp/internal/errs")
+//
+// While any wrapper packages are registered, this package, and its subpackages, are
+// treated as wrapper packages too.  A stack is never trimmed entirely: if all its frames
+// are from wrapper packages, it is kept as is.  Frames are only dropped from stacks
+// captured automatically, and by CaptureStack, AddStack, LocationOnly, and
+// CaptureStackHeadTail.
+func RegisterWrapperPackage(pkgPrefix string) {
+	wrapperPackagesLock.Lock()
+	defer wrapperPackagesLock.Unlock()
+
+	if len(wrapperPackages) == 0 {
+		wrapperPackages = []string{merryPackage}
+	}
+	// copy on write, so callers can read the list without holding the lock
+	wrapperPackages = append(wrapperPackages[:len(wrapperPackages):len(wrapperPackages)], strings.TrimSuffix(pkgPrefix, "/"))
+}
+
+func wrapperPackageList() []string {
+	wrapperPackagesLock.Lock()
+	defer wrapperPackagesLock.Unlock()
+
+	return wrapperPackages
+}
+
+// merryPackage is the path of this package.
+var merryPackage = packagePath(runtime.FuncForPC(reflect.ValueOf(New).Pointer()).Name())
+
+// leadingWrapperFrames returns the number of frames at the start of the stack which belong
+// to the wrapper packages.  Never returns len(s), unless s is empty.
+func leadingWrapperFrames(s []uintptr, wrappers []string) int {
+	for i, pc := range s {
+		frame, ok := resolveFrame(pc)
+		if !ok || !inPackages(packagePath(frame.Function), wrappers) {
+			return i
+		}
+	}
+	return 0
+}
+
+// inPackages returns true if pkg is one of the packages, or nested under one of them.
+func inPackages(pkg string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if pkg == prefix || strings.HasPrefix(pkg, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+var detailsLock sync.Mutex    // held by writers
+var detailFields atomic.Value // map[string]func(err error) interface{}, copied on write
+
+// registeredDetailFields returns the functions registered with RegisterDetailFunc.  The
+// map must not be modified.
+func registeredDetailFields() map[string]func(err error) interface{} {
+	fields, _ := detailFields.Load().(map[string]func(err error) interface{})
+	return fields
+}
 
 // RegisterDetail registers an error property key in a global registry, with a label.
 // See RegisterDetailFunc.  This function just wraps a call to Value(key) and passes
@@ -84,5 +340,251 @@ func RegisterDetailFunc(label string, f func(err error) interface{}) {
 	detailsLock.Lock()
 	defer detailsLock.Unlock()
 
-	detailFields[label] = f
+	// copy on write, so errors can be formatted without holding the lock
+	fields := registeredDetailFields()
+	updated := make(map[string]func(err error) interface{}, len(fields)+1)
+	for l, fn := range fields {
+		updated[l] = fn
+	}
+	updated[label] = f
+	detailFields.Store(updated)
+}
+
+var layersLock sync.Mutex
+var layers = map[string]string{}
+
+// RegisterLayer associates the packages under a package path prefix with the name of an
+// architectural layer, e.g.:
+//
+//	merry.RegisterLayer("myapp/internal/repo", "repository")
+//
+// The prefix matches the package with that path, and any packages nested under it.  If
+// several registered prefixes match a package, the longest wins.  See RecordLayer.
+func RegisterLayer(pkgPrefix, layer string) {
+	layersLock.Lock()
+	defer layersLock.Unlock()
+
+	layers[strings.TrimSuffix(pkgPrefix, "/")] = layer
+}
+
+// layerOf returns the layer registered for the package of the newest frame in the stack which
+// belongs to a registered layer, or "" if none do.
+func layerOf(stack []uintptr) string {
+	layersLock.Lock()
+	defer layersLock.Unlock()
+
+	if len(layers) == 0 || len(stack) == 0 {
+		return ""
+	}
+
+	for _, pc := range stack {
+		frame, ok := resolveFrame(pc)
+		if !ok {
+			continue
+		}
+		pkg := packagePath(frame.Function)
+		var match string
+		for prefix := range layers {
+			if (pkg == prefix || strings.HasPrefix(pkg, prefix+"/")) && len(prefix) > len(match) {
+				match = prefix
+			}
+		}
+		if match != "" {
+			return layers[match]
+		}
+	}
+	return ""
+}
+
+var kindsLock sync.Mutex       // held by writers
+var kindHTTPCodes atomic.Value // map[string]int, copied on write
+
+// RegisterKindHTTPCode maps an error kind to an HTTP status code.  HTTPCode() returns
+// this code for errors of this kind (see WithKind), if they don't have an HTTP code
+// attached.  An HTTP code attached with WithHTTPCode always takes precedence.  Registering
+// a code of 0 removes the kind's code.
+func RegisterKindHTTPCode(kind string, code int) {
+	kindsLock.Lock()
+	defer kindsLock.Unlock()
+
+	// copy on write, so HTTPCode can read the map without holding the lock
+	codes, _ := kindHTTPCodes.Load().(map[string]int)
+	updated := make(map[string]int, len(codes)+1)
+	for k, c := range codes {
+		updated[k] = c
+	}
+	if code == 0 {
+		delete(updated, kind)
+	} else {
+		updated[kind] = code
+	}
+	kindHTTPCodes.Store(updated)
+}
+
+func kindHTTPCode(kind string) int {
+	codes, _ := kindHTTPCodes.Load().(map[string]int)
+	return codes[kind]
+}
+
+type errorHTTPCode struct {
+	target error
+	code   int
+}
+
+var errorHTTPCodesLock sync.Mutex
+var errorHTTPCodes = []errorHTTPCode{
+	{target: context.DeadlineExceeded, code: 504},
+	{target: context.Canceled, code: 499},
+	{target: sql.ErrNoRows, code: 404},
+	{target: fs.ErrNotExist, code: 404},
+}
+
+// RegisterHTTPCode maps errors matching target with errors.Is() to an HTTP status code.
+// The AutoHTTPCode hook attaches this code to matching errors.  Some well-known errors
+// from the standard library are registered by default:
+//
+//	context.DeadlineExceeded: 504
+//	context.Canceled:         499
+//	sql.ErrNoRows:            404
+//	fs.ErrNotExist:           404 (also os.ErrNotExist)
+//
+// Registering a target again replaces its code.  If an error matches several targets,
+// the target registered first wins.
+func RegisterHTTPCode(target error, code int) {
+	errorHTTPCodesLock.Lock()
+	defer errorHTTPCodesLock.Unlock()
+
+	// copy on write, so registeredHTTPCode can search the list without holding the lock
+	mappings := make([]errorHTTPCode, 0, len(errorHTTPCodes)+1)
+	replaced := false
+	for _, m := range errorHTTPCodes {
+		if sameError(m.target, target) {
+			m.code = code
+			replaced = true
+		}
+		mappings = append(mappings, m)
+	}
+	if !replaced {
+		mappings = append(mappings, errorHTTPCode{target: target, code: code})
+	}
+	errorHTTPCodes = mappings
+}
+
+// registeredHTTPCode returns the code registered for the first target matching err, or 0.
+func registeredHTTPCode(err error) int {
+	errorHTTPCodesLock.Lock()
+	mappings := errorHTTPCodes
+	errorHTTPCodesLock.Unlock()
+
+	for _, m := range mappings {
+		if errors.Is(err, m.target) {
+			return m.code
+		}
+	}
+	return 0
+}
+
+// Config is a snapshot of this package's global configuration: the settings, hooks, and
+// registered details, layers, kind and error HTTP codes, public values, and wrapper
+// packages.  See Snapshot.
+type Config struct {
+	maxStackDepth        int32
+	captureStacks        int32
+	maxMessageLength     int32
+	maxCauseDepth        int32
+	httpCodeDefaulting   int32
+	debugChecks          int32
+	userMessageLocalizer func(key, locale string) string
+	frameFormatter       func(frame Frame) string
+	hooks                []Wrapper
+	onceHooks            []Wrapper
+	detailFields         map[string]func(err error) interface{}
+	kindHTTPCodes        map[string]int
+	layers               map[string]string
+	errorHTTPCodes       []errorHTTPCode
+	publicValues         []interface{}
+	wrapperPackages      []string
+}
+
+// Snapshot captures the global configuration, so it can be restored later.  Tests which
+// change the configuration can restore it when they are done with:
+//
+//	defer merry.Snapshot().Restore()
+//
+// Like the functions which install hooks, Snapshot and Restore are not thread safe with
+// respect to hooks.
+func Snapshot() Config {
+	c := Config{
+		maxStackDepth:        atomic.LoadInt32(&maxStackDepth),
+		captureStacks:        atomic.LoadInt32(&captureStacks),
+		maxMessageLength:     atomic.LoadInt32(&maxMessageLength),
+		maxCauseDepth:        atomic.LoadInt32(&maxCauseDepth),
+		httpCodeDefaulting:   atomic.LoadInt32(&httpCodeDefaulting),
+		debugChecks:          atomic.LoadInt32(&debugChecks),
+		userMessageLocalizer: UserMessageLocalizer(),
+		frameFormatter:       FrameFormatter(),
+		// the hooks, details, kind codes, error codes, public values, and wrapper
+		// packages are copied on write, so they can be shared with the snapshot
+		hooks:           hooks,
+		onceHooks:       onceHooks,
+		detailFields:    registeredDetailFields(),
+		publicValues:    publicValueKeys(),
+		wrapperPackages: wrapperPackageList(),
+	}
+	c.kindHTTPCodes, _ = kindHTTPCodes.Load().(map[string]int)
+
+	errorHTTPCodesLock.Lock()
+	c.errorHTTPCodes = errorHTTPCodes
+	errorHTTPCodesLock.Unlock()
+
+	layersLock.Lock()
+	c.layers = make(map[string]string, len(layers))
+	for prefix, layer := range layers {
+		c.layers[prefix] = layer
+	}
+	layersLock.Unlock()
+
+	return c
+}
+
+// Restore replaces the global configuration with the snapshot.
+func (c Config) Restore() {
+	atomic.StoreInt32(&maxStackDepth, c.maxStackDepth)
+	atomic.StoreInt32(&captureStacks, c.captureStacks)
+	atomic.StoreInt32(&maxMessageLength, c.maxMessageLength)
+	atomic.StoreInt32(&maxCauseDepth, c.maxCauseDepth)
+	atomic.StoreInt32(&httpCodeDefaulting, c.httpCodeDefaulting)
+	atomic.StoreInt32(&debugChecks, c.debugChecks)
+	SetUserMessageLocalizer(c.userMessageLocalizer)
+	SetFrameFormatter(c.frameFormatter)
+
+	hooks = c.hooks
+	onceHooks = c.onceHooks
+
+	detailsLock.Lock()
+	detailFields.Store(c.detailFields)
+	detailsLock.Unlock()
+
+	kindsLock.Lock()
+	kindHTTPCodes.Store(c.kindHTTPCodes)
+	kindsLock.Unlock()
+
+	errorHTTPCodesLock.Lock()
+	errorHTTPCodes = c.errorHTTPCodes
+	errorHTTPCodesLock.Unlock()
+
+	publicValuesLock.Lock()
+	publicValues = c.publicValues
+	publicValuesLock.Unlock()
+
+	wrapperPackagesLock.Lock()
+	wrapperPackages = c.wrapperPackages
+	wrapperPackagesLock.Unlock()
+
+	layersLock.Lock()
+	layers = make(map[string]string, len(c.layers))
+	for prefix, layer := range c.layers {
+		layers[prefix] = layer
+	}
+	layersLock.Unlock()
 }
@@ -71,9 +71,21 @@
 // how many frames to capture.  SetStackCaptureEnabled() can globally configure whether
 // stacks are captured by default.
 //
-// Wrap(err, NoStackCapture()) can be used to selectively suppress stack capture for a particular
+// Wrap(err, NoCaptureStack()) can be used to selectively suppress stack capture for a particular
 // error.
 //
+// Capturing stacks is the most expensive part of creating an error.  Hot code paths which create
+// many errors, whose stacks will never be looked at, should suppress capture on each call, rather
+// than toggling the global setting, which affects errors created concurrently by other goroutines:
+//
+//	for _, item := range items {
+//	  if err := validate(item); err != nil {
+//	    errs = append(errs, merry.Wrap(err, merry.NoCaptureStack()))
+//	  }
+//	}
+//
+// Errors created with Sentinel() don't have stacks either, until they are wrapped.
+//
 // Wrap(err, CaptureStack(false)) will capture a new stack at the Wrap call site, even if the err
 // already had an earlier stack attached.  The new stack overrides the older stack.
 //
@@ -1,9 +1,15 @@
 package merry
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"reflect"
 	"runtime"
+	"strconv"
+	"time"
 )
 
 // New creates a new error, with a stack attached.  The equivalent of golang's errors.New()
@@ -13,10 +19,34 @@ func New(msg string, wrappers ...Wrapper) error {
 
 // Errorf creates a new error with a formatted message and a stack.  The equivalent of golang's fmt.Errorf().
 // args may contain either arguments to format, or Wrapper options, which will be applied to the error.
+//
+// If the format wraps an error with the %w verb, that error is also set as the error's cause,
+// as if by WithCause, so Cause() returns it, and Details() prints it.  If the format has
+// several %w verbs (supported since go 1.20), the first wrapped error becomes the cause,
+// though errors.Is() and errors.As() still match all of them.  A WithCause option in args
+// takes precedence.
 func Errorf(format string, args ...interface{}) error {
 	fmtArgs, wrappers := splitWrappers(args)
 
-	return WrapSkipping(fmt.Errorf(format, fmtArgs...), 1, wrappers...)
+	err := fmt.Errorf(format, fmtArgs...)
+	if cause := wrappedError(err); cause != nil {
+		wrappers = append([]Wrapper{WithCause(cause)}, wrappers...)
+	}
+	return WrapSkipping(err, 1, wrappers...)
+}
+
+// wrappedError returns the error wrapped by an error returned by fmt.Errorf, or the first
+// error, if it wraps several.  Returns nil if it doesn't wrap an error.
+func wrappedError(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Unwrap() []error }:
+		if errs := e.Unwrap(); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+	return nil
 }
 
 // Sentinel creates an error without running hooks or capturing a stack.  It is intended
@@ -48,6 +78,54 @@ func Sentinelf(format string, args ...interface{}) error {
 	return ApplySkipping(fmt.Errorf(format, fmtArgs...), 1, wrappers...)
 }
 
+// Require checks a precondition, typically on an input.  If cond is true, it returns nil.
+// Otherwise, it returns an error with the formatted message, which is also used as the
+// user message, an HTTP code of 400, and a stack starting at the call site.  args can be
+// a mix of format arguments and Wrappers, which are applied after the defaults, so they
+// can override them.
+//
+//	if err := merry.Require(limit > 0, "limit must be positive, was %d", limit); err != nil {
+//	  return err
+//	}
+func Require(cond bool, format string, args ...interface{}) error {
+	if cond {
+		return nil
+	}
+
+	fmtArgs, wrappers := splitWrappers(args)
+	msg := fmt.Sprintf(format, fmtArgs...)
+	wrappers = append([]Wrapper{WithHTTPCode(http.StatusBadRequest), WithUserMessage(msg)}, wrappers...)
+
+	return WrapSkipping(errors.New(msg), 1, wrappers...)
+}
+
+// RequireNotNil is like Require, but checks that v is not nil.  Nil pointers, maps, slices,
+// channels, funcs and interfaces stored in v are also considered nil.  name is used in the
+// message, e.g. "user must not be nil".
+func RequireNotNil(v interface{}, name string, wrappers ...Wrapper) error {
+	if !isNil(v) {
+		return nil
+	}
+
+	msg := name + " must not be nil"
+	wrappers = append([]Wrapper{WithHTTPCode(http.StatusBadRequest), WithUserMessage(msg)}, wrappers...)
+
+	return WrapSkipping(errors.New(msg), 1, wrappers...)
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
 func splitWrappers(args []interface{}) ([]interface{}, []Wrapper) {
 	var wrappers []Wrapper
 
@@ -69,6 +147,11 @@ func splitWrappers(args []interface{}) ([]interface{}, []Wrapper) {
 // Wrap adds context to errors by applying Wrappers.  See WithXXX() functions for Wrappers supplied
 // by this package.
 //
+// To add a message to the error while wrapping it, use Prepend or Prependf, which also accept
+// Wrappers:
+//
+//	return merry.Prepend(err, "loading config", merry.WithHTTPCode(500))
+//
 // If StackCaptureEnabled is true, a stack starting at the caller will be automatically captured
 // and attached to the error.  This behavior can be overridden with wrappers which either capture
 // their own stacks, or suppress auto capture.
@@ -78,6 +161,169 @@ func Wrap(err error, wrappers ...Wrapper) error {
 	return WrapSkipping(err, 1, wrappers...)
 }
 
+// WrapAll applies Wrap to each error in errs, for code which processes batches.  Returns a
+// new slice, with nil errors left as nil.  Stacks are captured from the caller of WrapAll.
+//
+// If errs is nil, returns nil.
+func WrapAll(errs []error, wrappers ...Wrapper) []error {
+	if errs == nil {
+		return nil
+	}
+	wrapped := make([]error, len(errs))
+	for i, err := range errs {
+		wrapped[i] = WrapSkipping(err, 1, wrappers...)
+	}
+	return wrapped
+}
+
+// WrapContext is like Wrap, but if ctx has been canceled or has timed out, ctx.Err() is
+// attached to the error as its cause, unless the error already matches it.  If the error
+// already has a cause, the cause is kept, and ctx.Err() is attached with Classify instead.  This ensures
+// errors returned after a request is canceled reflect the cancellation, even if the
+// underlying error doesn't, so that errors.Is(err, context.Canceled) and
+// errors.Is(err, context.DeadlineExceeded) work, and the grpcstatus package maps the
+// error to the right code.
+//
+// If err is nil, returns nil.
+func WrapContext(ctx context.Context, err error, wrappers ...Wrapper) error {
+	if err == nil {
+		return nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		wrappers = append([]Wrapper{withCauseIfAbsent(ctxErr)}, wrappers...)
+	}
+
+	return WrapSkipping(err, 1, wrappers...)
+}
+
+// WrapDeadline wraps err with the deadline of ctx, if it has one, so timeout errors record
+// the budget which was exceeded.  Details() prints it as "Deadline: ...".  Contexts don't
+// record when their deadline was set, so to also record how long the operation waited,
+// attach the elapsed time with WithElapsedSince.  If ctx's deadline has been exceeded,
+// context.DeadlineExceeded is attached as the cause, like WrapContext, unless the error
+// already matches it.  Like WrapContext, an existing cause is kept.  See Deadline.
+//
+//	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+//	defer cancel()
+//	if err := client.Do(ctx, req); err != nil {
+//		return merry.WrapDeadline(ctx, err)
+//	}
+//
+// If err is nil, returns nil.
+func WrapDeadline(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var wrappers []Wrapper
+	if deadline, ok := ctx.Deadline(); ok {
+		wrappers = append(wrappers, WithValue(errKeyDeadline, deadline))
+	}
+	if ctx.Err() == context.DeadlineExceeded && !errors.Is(err, context.DeadlineExceeded) {
+		wrappers = append(wrappers, withCauseIfAbsent(context.DeadlineExceeded))
+	}
+
+	return WrapSkipping(err, 1, wrappers...)
+}
+
+// CloseWith closes closer, and reports the error it returns, if any, through errp.  It
+// should be deferred by a function with a named error result:
+//
+//	func writeFile(name string) (err error) {
+//	  f, err := os.Create(name)
+//	  if err != nil {
+//	    return err
+//	  }
+//	  defer merry.CloseWith(f, &err, "closing file")
+//	  ...
+//	}
+//
+// The close error is prepended with msg, if msg isn't empty.  If *errp is nil, it is set to
+// the close error.  Otherwise, the close error is attached to *errp as a suppressed error,
+// so it doesn't replace the original error.  See WithSuppressed.
+func CloseWith(closer io.Closer, errp *error, msg string) {
+	cerr := closer.Close()
+	if cerr == nil || errp == nil {
+		return
+	}
+
+	var wrappers []Wrapper
+	if msg != "" {
+		wrappers = append(wrappers, PrependMessage(msg))
+	}
+	cerr = WrapSkipping(cerr, 1, wrappers...)
+
+	if *errp == nil {
+		*errp = cerr
+		return
+	}
+	*errp = WrapSkipping(*errp, 1, WithSuppressed(cerr))
+}
+
+// WrapRecover turns a value recovered from a panic into an error.  It should be called from
+// the deferred function which recovers the panic:
+//
+//	defer func() {
+//	  if r := recover(); r != nil {
+//	    err = merry.WrapRecover(r)
+//	  }
+//	}()
+//
+// If r is an error, it is wrapped, otherwise a new error is created from r.  The error's
+// message is prefixed with "panic: ".  The stack is captured from where the panic occurred,
+// rather than where it was recovered, unless the error already has a stack.  The wrappers
+// are applied to the error.
+//
+// If r is nil, returns nil.
+func WrapRecover(r interface{}, wrappers ...Wrapper) error {
+	if r == nil {
+		return nil
+	}
+
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+
+	wrappers = append([]Wrapper{PrependMessage("panic")}, wrappers...)
+	if StackCaptureEnabled() {
+		wrappers = append(wrappers, withStackIfAbsent(capturedValue(panicStack())))
+	}
+
+	return WrapSkipping(err, 1, wrappers...)
+}
+
+// panicStack returns the stack of the panicking goroutine, starting from the function which
+// panicked.  If there is no panic in progress, it returns the stack of the caller's caller.
+func panicStack() []uintptr {
+	s := make([]uintptr, 64)
+	for {
+		length := runtime.Callers(3, s)
+		if length < len(s) {
+			s = s[:length]
+			break
+		}
+		s = make([]uintptr, 2*len(s))
+	}
+
+	for i, pc := range s {
+		if fn := runtime.FuncForPC(pc); fn != nil && fn.Name() == "runtime.gopanic" {
+			s = s[i+1:]
+			break
+		}
+	}
+
+	switch depth := MaxStackDepth(); {
+	case depth <= 0:
+		s = s[:0]
+	case len(s) > depth:
+		s = append(s[:depth], truncatedFrames)
+	}
+
+	return s
+}
+
 // WrapSkipping is like Wrap, but the captured stacks will start `skip` frames
 // further up the call stack.  If skip is 0, it behaves the same as Wrap.
 func WrapSkipping(err error, skip int, wrappers ...Wrapper) error {
@@ -111,20 +357,48 @@ func Apply(err error, wrappers ...Wrapper) error {
 }
 
 // ApplySkipping is like WrapSkipping, but does not execute hooks or do automatic stack capture.  It just
-// applies the wrappers to the error.  It is useful in Wrapper implementations which
+// applies the wrappers to the error.  nil wrappers are skipped.  It is useful in Wrapper implementations which
 // // want to apply other Wrappers without starting an infinite recursion.
 func ApplySkipping(err error, skip int, wrappers ...Wrapper) error {
 	if err == nil {
 		return nil
 	}
 
-	for _, w := range wrappers {
-		err = w.Wrap(err, skip+1)
+	for i, w := range wrappers {
+		switch t := w.(type) {
+		case nil:
+			// skip nil wrappers, which are easy to pass by accident when building
+			// wrapper lists conditionally
+			continue
+		case WrapperFunc:
+			if t == nil {
+				continue
+			}
+		case *groupWrapper:
+			// the remaining wrappers are applied within the group
+			return t.apply(err, skip+1, wrappers[i+1:])
+		}
+		wrapped := w.Wrap(err, skip+1)
+		if DebugChecks() {
+			checkWrapper(w, err, wrapped)
+		}
+		err = wrapped
 	}
 
 	return err
 }
 
+// checkWrapper panics if wrapped isn't a valid result of applying w to err.  See SetDebugChecks.
+func checkWrapper(w Wrapper, err, wrapped error) {
+	if wrapped == nil {
+		panic(fmt.Sprintf("merry: wrapper %T returned nil when wrapping error: %v", w, err))
+	}
+	// errors.Is() can't match errors which aren't comparable by themselves
+	if reflect.TypeOf(err).Comparable() && !errors.Is(wrapped, err) {
+		panic(fmt.Sprintf("merry: wrapper %T returned an error which doesn't wrap error: %v", w, err))
+	}
+}
+
 // Prepend is a convenience function for the PrependMessage wrapper.  It eases migration
 // from merry v1.  It accepts a varargs of additional Wrappers.
 func Prepend(err error, msg string, wrappers ...Wrapper) error {
@@ -133,10 +407,24 @@ func Prepend(err error, msg string, wrappers ...Wrapper) error {
 
 // Prependf is a convenience function for the PrependMessagef wrapper.  It eases migration
 // from merry v1.  The args can be format arguments mixed with Wrappers.
+//
+// Like Errorf, if the format wraps an error with the %w verb, that error is set as err's
+// cause, and is formatted like %v.  If err already has a cause, the cause is kept, and
+// the wrapped error is attached with Classify instead, so errors.Is() still matches it.
 func Prependf(err error, format string, args ...interface{}) error {
 	fmtArgs, wrappers := splitWrappers(args)
 
-	return WrapSkipping(err, 1, append(wrappers, PrependMessagef(format, fmtArgs...))...)
+	msg := fmt.Errorf(format, fmtArgs...)
+	if cause := wrappedError(msg); cause != nil {
+		wrappers = append([]Wrapper{withCauseIfAbsent(cause)}, wrappers...)
+	}
+	return WrapSkipping(err, 1, append(wrappers, PrependMessage(msg.Error()))...)
+}
+
+// PrependUnique is a convenience function for the PrependMessageUnique wrapper.  It accepts
+// a varargs of additional Wrappers.
+func PrependUnique(err error, msg string, wrappers ...Wrapper) error {
+	return WrapSkipping(err, 1, append(wrappers, PrependMessageUnique(msg))...)
 }
 
 // Append is a convenience function for the AppendMessage wrapper.  It eases migration
@@ -147,10 +435,109 @@ func Append(err error, msg string, wrappers ...Wrapper) error {
 
 // Appendf is a convenience function for the AppendMessagef wrapper.  It eases migration
 // from merry v1.  The args can be format arguments mixed with Wrappers.
+//
+// Like Errorf, if the format wraps an error with the %w verb, that error is set as err's
+// cause, and is formatted like %v.  If err already has a cause, the cause is kept, and
+// the wrapped error is attached with Classify instead, so errors.Is() still matches it.
 func Appendf(err error, format string, args ...interface{}) error {
 	fmtArgs, wrappers := splitWrappers(args)
 
-	return WrapSkipping(err, 1, append(wrappers, AppendMessagef(format, fmtArgs...))...)
+	msg := fmt.Errorf(format, fmtArgs...)
+	if cause := wrappedError(msg); cause != nil {
+		wrappers = append([]Wrapper{withCauseIfAbsent(cause)}, wrappers...)
+	}
+	return WrapSkipping(err, 1, append(wrappers, AppendMessage(msg.Error()))...)
+}
+
+// AppendUnique is a convenience function for the AppendMessageUnique wrapper.  It accepts
+// a varargs of additional Wrappers.
+func AppendUnique(err error, msg string, wrappers ...Wrapper) error {
+	return WrapSkipping(err, 1, append(wrappers, AppendMessageUnique(msg))...)
+}
+
+// Transform rebuilds an error, applying fn to each of its layers.  It can be used to
+// sanitize errors, e.g. to redact values before an error is logged or returned.
+//
+// The layers of an error are the wrappers added by this package, like the wrappers added
+// by WithValue or WithCause, plus the innermost error they wrap.  fn is called with each
+// layer, starting with the innermost.  It may return:
+//
+//   - the layer, unchanged, to keep it.
+//   - nil, to drop the layer.  The innermost error can't be dropped, and is kept.
+//   - an error created by wrapping the layer with a new value or cause, e.g. with Set(),
+//     to replace the layer's value or cause with the new one.
+//   - any other error, which replaces the layer and all the layers beneath it.
+//
+// Causes are transformed as well.  Errors wrapping the chain which were not created by
+// this package are treated as the innermost error, and are not traversed.  See LayerValue
+// for inspecting the value attached by a layer.
+//
+//	// remove the password from the error, and its causes
+//	err = merry.Transform(err, func(layer error) error {
+//		if key, _, ok := merry.LayerValue(layer); ok && key == passwordKey {
+//			return nil
+//		}
+//		return layer
+//	})
+//
+// If err is nil, returns nil.
+func Transform(err error, fn func(layer error) error) error {
+	if err == nil {
+		return nil
+	}
+
+	var layers []error
+	root := err
+
+collect:
+	for {
+		switch root.(type) {
+		case *errWithValue, *errWithCause:
+			layers = append(layers, root)
+		case *formatError:
+			// formatErrors just wrap foreign errors so they implement fmt.Formatter
+		default:
+			break collect
+		}
+		root = unwrapLayer(root)
+	}
+
+	result := root
+	if r := fn(root); r != nil {
+		result = r
+	}
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		switch t := fn(layers[i]).(type) {
+		case nil:
+			// drop the layer
+		case *errWithValue:
+			result = &errWithValue{err: result, key: t.key, value: t.value}
+		case *errWithCause:
+			result = &errWithCause{err: result, cause: Transform(t.cause, fn)}
+		default:
+			result = t
+		}
+	}
+
+	if _, ok := err.(fmt.Formatter); ok {
+		if _, ok := result.(fmt.Formatter); !ok {
+			result = &formatError{result}
+		}
+	}
+
+	return result
+}
+
+// LayerValue returns the key and value attached to err by its outermost layer, if that
+// layer was added by this package with WithValue, Set, or any of this package's other
+// value wrappers.  Otherwise, returns false.  Unlike Lookup, it doesn't search the rest of
+// the chain.  It is intended for use with Transform.
+func LayerValue(err error) (key, value interface{}, ok bool) {
+	if e, ok := err.(*errWithValue); ok {
+		return e.key, e.value, true
+	}
+	return nil, nil, false
 }
 
 // Value returns the value for key, or nil if not set.
@@ -160,11 +547,76 @@ func Value(err error, key interface{}) interface{} {
 	return v
 }
 
+// Severity returns the severity of the error, e.g. "warning" for errors downgraded with
+// Downgrade.  Returns empty if not set.  Will not search causes.
+// If err is nil, returns "".
+func Severity(err error) string {
+	severity, _ := Value(err, errKeySeverity).(string)
+	return severity
+}
+
+// IsFatal returns true if err should fail the operation which returned it: it is not nil,
+// and it wasn't downgraded with Downgrade.  Will not search causes.
+func IsFatal(err error) bool {
+	return err != nil && Severity(err) != "warning"
+}
+
+// WasLogged returns true if the error was marked as logged with MarkLogged.  Will not
+// search causes, so a new error which has a logged error as its cause is logged again.
+// If err is nil, returns false.
+func WasLogged(err error) bool {
+	logged, _ := Value(err, errKeyLogged).(bool)
+	return logged
+}
+
+// HasValue returns true if a value is attached to err with key, even if the value is nil.
+// Will not search causes.
+func HasValue(err error, key interface{}) bool {
+	_, ok := Lookup(err, key)
+	return ok
+}
+
+// ValueMatcher returns a target for errors.Is, which matches errors which have value attached
+// with key:
+//
+//	if errors.Is(err, merry.ValueMatcher("kind", "notfound")) {
+//
+// Like other errors.Is targets, it matches values attached to any error in the chain,
+// including causes.  Values masked with WithoutValue don't match.
+func ValueMatcher(key, value interface{}) error {
+	return &valueMatcher{key: key, value: value}
+}
+
+// ValueAs is a generic form of Value.  It returns the value for key, converted to type T.
+// Returns the zero value of T and false if the value is not set, or is not a T.
+//
+//	id, ok := merry.ValueAs[int](err, "userID")
+//
+// If err is nil, returns the zero value of T and false.  Will not search causes.
+func ValueAs[T any](err error, key interface{}) (T, bool) {
+	v, ok := Value(err, key).(T)
+	return v, ok
+}
+
 // Lookup returns the value for the key, and a boolean indicating
 // whether the value was set.  Will not search causes.
 //
+// If the chain branches, because an error in it wraps several errors by implementing
+// Unwrap() []error, like the errors returned by errors.Join, the branches are searched
+// depth first, in order.  The first value found wins, so values in the leftmost branch
+// take precedence.  A value removed with WithoutValue counts as found, so removing a key
+// in one branch masks the values in the branches to its right.  Values, RangeValues, and
+// the other functions which read values follow the same rule.
+//
 // if err is nil, returns nil and false.
 func Lookup(err error, key interface{}) (interface{}, bool) {
+	v, ok, _ := lookup(err, key)
+	return v, ok
+}
+
+// lookup implements Lookup.  found is true if the key is attached to err, even if its
+// value was removed.
+func lookup(err error, key interface{}) (v interface{}, ok, found bool) {
 	var merr interface {
 		error
 		isMerryError()
@@ -188,40 +640,82 @@ func Lookup(err error, key interface{}) (interface{}, bool) {
 	//   against the result.  We have to use errors.As(), to allow the shims to delegate
 	//   the type assertion to the raw error correctly.
 	//
-	// Based on all these constraints, we use errors.As() with an internal interface
-	// that can only be implemented by our internal error types.  When one is found,
-	// we handle each of our internal types as a special case.  For errWithCause, we
-	// traverse to the wrapped error, ignoring the cause and the funky Unwrap logic.
-	// We could have just used errors.As(err, *errWithValue), but that would have
-	// traversed into the causes.
+	// - Errors can wrap several errors, with Unwrap() []error.  errors.As() searches
+	//   the branches in order, but stops at the first merry error it finds, even if
+	//   that error doesn't have the key, and the next branch does.
+	//
+	// Based on all these constraints, we step through the chain like errors.As() would,
+	// looking for an internal interface that can only be implemented by our internal
+	// error types, letting shims delegate the search with their As() methods.  When one
+	// is found, we handle each of our internal types as a special case.  For errWithCause,
+	// we traverse to the wrapped error, ignoring the cause and the funky Unwrap logic.
+	// When the chain branches, each branch is searched in turn.
 
 	for {
 		switch t := err.(type) {
+		case nil:
+			return nil, false, false
 		case *errWithValue:
 			if t.key == key {
-				return t.value, true
+				if _, ok := t.value.(removedValue); ok {
+					return nil, false, true
+				}
+				return t.value, true, true
 			}
 			err = t.err
 		case *errWithCause:
 			err = t.err
 		default:
-			if errors.As(err, &merr) {
+			if x, ok := err.(interface{ As(interface{}) bool }); ok && x.As(&merr) {
 				err = merr
-			} else {
-				return nil, false
+				continue
 			}
+			if x, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, branch := range x.Unwrap() {
+					if v, ok, found := lookup(branch, key); found {
+						return v, ok, true
+					}
+				}
+				return nil, false, false
+			}
+			err = errors.Unwrap(err)
 		}
 	}
 }
 
 // Values returns a map of all values attached to the error
 // If a key has been attached multiple times, the map will
-// contain the last value mapped
+// contain the last value mapped.  If the chain branches, values
+// in the leftmost branch take precedence, like Lookup.
 // If e is nil, returns nil.
 func Values(err error) map[interface{}]interface{} {
-	var values map[interface{}]interface{}
+	values := collectValues(err, nil)
+
+	for k, v := range values {
+		switch v.(type) {
+		case removedValue:
+			delete(values, k)
+		case *capturedStack:
+			values[k] = v.(*capturedStack).stack
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
 
+	return values
+}
+
+// collectValues adds the values attached to err to values, unless
+// their keys were already added.
+func collectValues(err error, values map[interface{}]interface{}) map[interface{}]interface{} {
 	for err != nil {
+		if x, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, branch := range x.Unwrap() {
+				values = collectValues(branch, values)
+			}
+			return values
+		}
 		if e, ok := err.(*errWithValue); ok {
 			if _, ok := values[e.key]; !ok {
 				if values == nil {
@@ -229,72 +723,990 @@ func Values(err error) map[interface{}]interface{} {
 				}
 				values[e.key] = e.value
 			}
+			err = e.err
+			continue
 		}
 		err = errors.Unwrap(err)
 	}
-
 	return values
 }
 
-// Stack returns the stack attached to an error, or nil if one is not attached
-// If e is nil, returns nil.
-func Stack(err error) []uintptr {
-	stack, _ := Value(err, errKeyStack).([]uintptr)
-	return stack
+// RangeValues calls fn for each value attached to the error, like Values, but without
+// allocating a map.  If a key has been attached multiple times, fn is only called with the
+// last value mapped.  Iteration stops if fn returns false.  Will not search causes.
+//
+// It is intended for hot paths which just enumerate an error's values once.
+func RangeValues(err error, fn func(key, value interface{}) bool) {
+	// chains are typically short, so a linear search of the keys seen so
+	// far is cheaper than a map
+	var buf [16]interface{}
+	rangeValues(err, buf[:0], fn)
 }
 
-// HTTPCode converts an error to an http status code.  All errors
-// map to 500, unless the error has an http code attached.
-// If e is nil, returns 200.
-func HTTPCode(err error) int {
-	if err == nil {
-		return 200
+// rangeValues calls fn for each value attached to err whose key isn't in seen.  Returns
+// seen, with the keys added, and false if fn returned false.
+func rangeValues(err error, seen []interface{}, fn func(key, value interface{}) bool) ([]interface{}, bool) {
+outer:
+	for ; err != nil; err = unwrapLayer(err) {
+		if x, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, branch := range x.Unwrap() {
+				var more bool
+				if seen, more = rangeValues(branch, seen, fn); !more {
+					return seen, false
+				}
+			}
+			return seen, true
+		}
+		e, ok := err.(*errWithValue)
+		if !ok {
+			continue
+		}
+		for _, k := range seen {
+			if k == e.key {
+				continue outer
+			}
+		}
+		seen = append(seen, e.key)
+		value := e.value
+		switch v := value.(type) {
+		case removedValue:
+			continue
+		case *capturedStack:
+			value = v.stack
+		}
+		if !fn(e.key, value) {
+			return seen, false
+		}
 	}
+	return seen, true
+}
 
-	code, _ := Value(err, errKeyHTTPCode).(int)
-	if code == 0 {
-		return 500
+// Fields returns the values attached to the error with string keys, as a map.  Values
+// attached within a group (see WithGroup) are nested in a map under the group's name.
+// If a key has been attached multiple times, the map will contain the last value mapped.
+// Will not search causes.
+//
+// Values attached with non-string keys, including those attached by this package's
+// own wrappers, are not included.  Fields is intended for adding an error's context to
+// structured logs.
+//
+// If err is nil or has no fields, returns nil.
+func Fields(err error) map[string]interface{} {
+	var fields map[string]interface{}
+
+	for ; err != nil; err = unwrapLayer(err) {
+		e, ok := err.(*errWithValue)
+		if !ok {
+			continue
+		}
+
+		groups, name, ok := fieldPath(e.key)
+		if !ok {
+			continue
+		}
+
+		if fields == nil {
+			fields = map[string]interface{}{}
+		}
+
+		m := fields
+		for _, group := range groups {
+			v, exists := m[group]
+			if !exists {
+				v = map[string]interface{}{}
+				m[group] = v
+			}
+			if m, ok = v.(map[string]interface{}); !ok {
+				// a value with the group's name was already attached
+				break
+			}
+		}
+
+		if m != nil {
+			if _, exists := m[name]; !exists {
+				m[name] = e.value
+			}
+		}
 	}
 
-	return code
+	if fields != nil && pruneRemoved(fields) {
+		return nil
+	}
+
+	return fields
 }
 
-// UserMessage returns the end-user safe message.  Returns empty if not set.
-// If e is nil, returns "".
-func UserMessage(err error) string {
-	msg, _ := Value(err, errKeyUserMessage).(string)
-	return msg
+// pruneRemoved deletes the fields masked by WithoutValue, and groups which are left empty.
+// Returns true if m is empty.
+func pruneRemoved(m map[string]interface{}) bool {
+	for k, v := range m {
+		switch t := v.(type) {
+		case removedValue:
+			delete(m, k)
+		case map[string]interface{}:
+			if pruneRemoved(t) {
+				delete(m, k)
+			}
+		}
+	}
+	return len(m) == 0
 }
 
-// Cause returns the cause of the argument.  If e is nil, or has no cause,
-// nil is returned.
-func Cause(err error) error {
-	var causer *errWithCause
-	if errors.As(err, &causer) {
-		return causer.cause
+// fieldPath returns the names of the groups a key is nested in, and the key's name.  Returns
+// false if the key is not a field key.
+func fieldPath(key interface{}) (groups []string, name string, ok bool) {
+	switch k := key.(type) {
+	case string:
+		return nil, k, true
+	case groupKey:
+		groups, name, ok = fieldPath(k.key)
+		return append([]string{k.group}, groups...), name, ok
+	default:
+		return nil, "", false
 	}
-	return nil
 }
 
-// RegisteredDetails extracts details registered with RegisterDetailFunc from an error, and
-// returns them as a map.  Values may be nil.
+// Stack returns the stack attached to an error, or nil if one is not attached
+// If e is nil, returns nil.
 //
-// If err is nil or there are no registered details, nil is returned.
-func RegisteredDetails(err error) map[string]interface{} {
-	detailsLock.Lock()
-	defer detailsLock.Unlock()
-
-	if len(detailFields) == 0 || err == nil {
-		return nil
-	}
+// The stack only contains the program counters of frames.  If frames were elided by
+// CaptureStackHeadTail, or the stack was truncated at MaxStackDepth(), Stacktrace() and
+// Frames() mark where the frames are missing.
+func Stack(err error) []uintptr {
+	return publicStack(Value(err, errKeyStack))
+}
 
-	dets := map[string]interface{}{}
+// markedStack returns the stack attached to an error, including the markers of a
+// captured stack.
+func markedStack(err error) []uintptr {
+	return stackValue(Value(err, errKeyStack))
+}
 
-	for label, f := range detailFields {
-		dets[label] = f(err)
+// stackValue returns the stack stored in a value, including the markers of a
+// captured stack.
+func stackValue(v interface{}) []uintptr {
+	switch s := v.(type) {
+	case []uintptr:
+		return s
+	case *capturedStack:
+		return s.marked
 	}
-
-	return dets
+	return nil
+}
+
+// publicStack returns the stack stored in a value, without the markers of a captured stack.
+func publicStack(v interface{}) []uintptr {
+	if s, ok := v.(*capturedStack); ok {
+		v = s.stack
+	}
+	stack, _ := v.([]uintptr)
+	return stack
+}
+
+// Stacks returns each distinct stack attached to the error, searching both the
+// chain of wrapped errors and the chain of causes.  Stacks are returned in the order
+// they are found: stacks attached to outer wrappers come before the stacks they wrap,
+// which come before the stacks of causes.  Boundary stacks attached with AddStack(), and
+// named stacks attached with WithNamedStack(), are included.
+//
+// Stacks which overlap are merged: if a stack is a prefix or suffix of another, e.g.
+// because an error and its cause were created in the same place, or by the same caller,
+// only the longer stack is returned, in the place of the first of them.
+//
+// If err is nil, or no stacks are attached, returns nil.
+func Stacks(err error) [][]uintptr {
+	var stacks [][]uintptr
+
+	for ; err != nil; err = Cause(err) {
+		for layer := err; layer != nil; layer = unwrapLayer(layer) {
+			e, ok := layer.(*errWithValue)
+			if !ok {
+				continue
+			}
+			if _, named := e.key.(namedStackKey); !named && e.key != errKeyStack && e.key != errKeyBoundaryStack {
+				continue
+			}
+			if stack := publicStack(e.value); len(stack) > 0 {
+				stacks = mergeStack(stacks, stack)
+			}
+		}
+	}
+
+	return stacks
+}
+
+// NamedStacks returns the stacks attached to the error with WithNamedStack, by label.
+// Will not search causes.
+//
+// If err is nil, or has no named stacks, returns nil.
+func NamedStacks(err error) map[string][]uintptr {
+	var stacks map[string][]uintptr
+
+	for layer := err; layer != nil; layer = unwrapLayer(layer) {
+		e, ok := layer.(*errWithValue)
+		if !ok {
+			continue
+		}
+		k, ok := e.key.(namedStackKey)
+		if !ok {
+			continue
+		}
+		if stacks == nil {
+			stacks = map[string][]uintptr{}
+		}
+		if _, exists := stacks[k.label]; !exists {
+			stacks[k.label], _ = e.value.([]uintptr)
+		}
+	}
+
+	return stacks
+}
+
+// boundaryStacks returns the stacks attached to err with AddStack(), oldest first.
+// The causes of err are not searched.
+func boundaryStacks(err error) [][]uintptr {
+	var stacks [][]uintptr
+
+	for layer := err; layer != nil; layer = unwrapLayer(layer) {
+		if e, ok := layer.(*errWithValue); ok && e.key == errKeyBoundaryStack {
+			if stack := stackValue(e.value); len(stack) > 0 {
+				stacks = append([][]uintptr{stack}, stacks...)
+			}
+		}
+	}
+
+	return stacks
+}
+
+// mergeStack adds stack to stacks, unless it is covered by one of them.  Stacks which are
+// covered by stack are replaced by it.
+func mergeStack(stacks [][]uintptr, stack []uintptr) [][]uintptr {
+	if coveredStack(stacks, stack) {
+		return stacks
+	}
+
+	merged := stacks[:0]
+	added := false
+	for _, s := range stacks {
+		if stackCovers(stack, s) {
+			if !added {
+				merged = append(merged, stack)
+				added = true
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	if !added {
+		merged = append(merged, stack)
+	}
+	return merged
+}
+
+// coveredStack returns true if stack is covered by one of stacks.
+func coveredStack(stacks [][]uintptr, stack []uintptr) bool {
+	for _, s := range stacks {
+		if stackCovers(s, stack) {
+			return true
+		}
+	}
+	return false
+}
+
+// stackCovers returns true if short is a prefix or a suffix of long, so long includes all
+// the frames of short.  A prefix may end with the truncatedFrames marker.
+func stackCovers(long, short []uintptr) bool {
+	if len(short) > 0 && short[len(short)-1] == truncatedFrames {
+		if len(long) >= len(short) && stackEqual(long[:len(short)-1], short[:len(short)-1]) {
+			return true
+		}
+	}
+	if len(long) < len(short) {
+		return false
+	}
+	return stackEqual(long[:len(short)], short) || stackEqual(long[len(long)-len(short):], short)
+}
+
+func stackEqual(s1, s2 []uintptr) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for i := range s1 {
+		if s1[i] != s2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// unwrapLayer returns the next error in err's chain of wrapped errors.  Unlike
+// errors.Unwrap, it will not descend into the causes attached with WithCause.
+func unwrapLayer(err error) error {
+	switch t := err.(type) {
+	case *errWithValue:
+		return t.err
+	case *errWithCause:
+		return t.err
+	default:
+		return errors.Unwrap(err)
+	}
+}
+
+// HTTPCode converts an error to an http status code.  All errors
+// map to 500, unless the error has an http code attached, or has a kind
+// with an http code registered with RegisterKindHTTPCode.  If
+// HTTPCodeDefaulting() is false, errors without an http code map to 0 instead.
+// If e is nil, returns 200.
+func HTTPCode(err error) int {
+	if err == nil {
+		return 200
+	}
+
+	code := httpCode(err)
+	if code == 0 && HTTPCodeDefaulting() {
+		return 500
+	}
+
+	return code
+}
+
+// httpCode returns the http code attached to err, or registered for its kind, or 0.
+func httpCode(err error) int {
+	code := attachedHTTPCode(err)
+	if code == 0 {
+		if kind := KindOf(err); kind != "" {
+			code = kindHTTPCode(kind)
+		}
+	}
+	return code
+}
+
+// AttachedHTTPCode returns the http code attached to the error with WithHTTPCode, and
+// whether one is attached.  Unlike HTTPCode, it doesn't fall back to the code registered
+// for the error's kind, or to 500.  Will not search causes.
+func AttachedHTTPCode(err error) (code int, ok bool) {
+	code = attachedHTTPCode(err)
+	return code, code != 0
+}
+
+// attachedHTTPCode returns the http code attached to err, resolving the code attached
+// by AutoHTTPCode, or 0 if none is attached.
+func attachedHTTPCode(err error) int {
+	switch v := Value(err, errKeyHTTPCode).(type) {
+	case int:
+		return v
+	case autoHTTPCode:
+		return registeredHTTPCode(err)
+	}
+	return 0
+}
+
+// HTTPStatusText returns the standard text for the error's HTTP code, as returned by
+// HTTPCode(), e.g. "Not Found" for 404.  Returns "" for unknown codes.
+// If err is nil, returns "OK".
+func HTTPStatusText(err error) string {
+	return http.StatusText(HTTPCode(err))
+}
+
+// HTTPCodeDeep is like HTTPCode, but if the error has no http code, it searches the chain
+// of causes, and returns the first http code found.  At each error, the code is found like
+// HTTPCode finds it: the code attached to the error, or else the code registered for its
+// kind.  Codes of outer errors take precedence over the codes of their causes.  If no codes
+// are found, it returns HTTPCode(err).
+// If err is nil, returns 200.
+func HTTPCodeDeep(err error) int {
+	for e := err; e != nil; e = Cause(e) {
+		if code := httpCode(e); code != 0 {
+			return code
+		}
+	}
+	return HTTPCode(err)
+}
+
+// HTTPHeaders returns the HTTP response headers attached to the error with WithHTTPHeaders.
+// The returned headers are a copy, which the caller may modify.
+// If err is nil, or has no headers attached, returns nil.
+func HTTPHeaders(err error) http.Header {
+	h, _ := Value(err, errKeyHTTPHeaders).(http.Header)
+	return h.Clone()
+}
+
+// Suppressed returns the errors attached to err with WithSuppressed, in the order they
+// were attached.  Will not search causes.
+//
+// If err is nil, or has no suppressed errors, returns nil.
+func Suppressed(err error) []error {
+	suppressed, _ := Value(err, errKeySuppressed).([]error)
+	if len(suppressed) == 0 {
+		return nil
+	}
+	return append([]error(nil), suppressed...)
+}
+
+// RetryAfter returns the retry delay attached to the error with WithRetryAfter.  The ok
+// return value is false if no delay is attached.
+// If err is nil, returns 0, false.
+func RetryAfter(err error) (d time.Duration, ok bool) {
+	d, ok = Value(err, errKeyRetryAfter).(time.Duration)
+	return d, ok
+}
+
+// Deadline returns the deadline attached to the error by WrapDeadline.  The ok return
+// value is false if no deadline is attached.
+// If err is nil, returns the zero time, false.
+func Deadline(err error) (deadline time.Time, ok bool) {
+	deadline, ok = Value(err, errKeyDeadline).(time.Time)
+	return deadline, ok
+}
+
+// Message returns the error's bare message: the message set with WithMessage, or any of the
+// wrappers which modify the message, or otherwise the message of the error wrapped by this
+// package.  Unlike err.Error(), it never includes the details printed by AlwaysVerbose
+// errors, and it isn't truncated at MaxMessageLength().  Only the layers added by this
+// package are searched: the message of an error from another package, e.g. one wrapped
+// with fmt.Errorf(), is returned as is.
+//
+// Like err.Error(), if the message is empty, the user message is returned instead.
+//
+// If err is nil, returns "".
+func Message(err error) string {
+	for err != nil {
+		switch t := err.(type) {
+		case *errWithValue:
+			if msg, ok := t.value.(string); ok && t.key == errKeyMessage {
+				return msg
+			}
+			if t.key == errKeyUserMessage {
+				if msg := Message(t.err); msg != "" {
+					return msg
+				}
+				msg, _ := t.value.(string)
+				return msg
+			}
+			err = t.err
+		case *errWithCause:
+			err = t.err
+		case *formatError:
+			err = t.error
+		default:
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// MessageHistory returns the messages set on the error by WithMessage, Prepend, Append,
+// and the other wrappers which modify the message, outermost (latest) first, followed by
+// the original message of the error wrapped by this package.  Consecutive duplicates are
+// only included once.  This shows how a composed message was built up, e.g.:
+//
+//	err := merry.Prepend(merry.Append(merry.New("boom"), "db"), "load user")
+//	merry.MessageHistory(err) // ["load user: boom: db", "boom: db", "boom"]
+//
+// Like Message, only the layers added by this package are searched.
+//
+// If err is nil, returns nil.
+func MessageHistory(err error) []string {
+	var history []string
+	add := func(msg string) {
+		if len(history) == 0 || history[len(history)-1] != msg {
+			history = append(history, msg)
+		}
+	}
+
+	for err != nil {
+		switch t := err.(type) {
+		case *errWithValue:
+			if msg, ok := t.value.(string); ok && t.key == errKeyMessage {
+				add(msg)
+			}
+			err = t.err
+		case *errWithCause:
+			err = t.err
+		case *formatError:
+			err = t.error
+		default:
+			add(err.Error())
+			return history
+		}
+	}
+	return history
+}
+
+// Build returns the version of the binary which created the error, as recorded by the
+// WithBuildInfo hook.  Returns empty if not recorded.
+// If err is nil, returns "".
+func Build(err error) string {
+	build, _ := Value(err, errKeyBuild).(string)
+	return build
+}
+
+// Caller returns the caller captured by WithCaller, formatted like SourceLine, or "".
+// If err is nil, returns "".
+func Caller(err error) string {
+	pc, ok := Value(err, errKeyCaller).(callerPC)
+	if !ok {
+		return ""
+	}
+	return pc.String()
+}
+
+// PreviousMessage returns the message overridden by SetMessageKeepingOld, or "".
+// If err is nil, returns "".
+func PreviousMessage(err error) string {
+	msg, _ := Value(err, errKeyPreviousMessage).(string)
+	return msg
+}
+
+// Hint returns the hint attached to the error with WithHint, or "".
+// If err is nil, returns "".
+func Hint(err error) string {
+	hint, _ := Value(err, errKeyHint).(string)
+	return hint
+}
+
+// Elapsed returns the elapsed time attached to the error with WithElapsedSince.  The ok
+// return value is false if no elapsed time is attached.
+// If err is nil, returns 0, false.
+func Elapsed(err error) (d time.Duration, ok bool) {
+	d, ok = Value(err, errKeyElapsed).(time.Duration)
+	return d, ok
+}
+
+// Layer returns the architectural layer which created the error, as recorded by the
+// RecordLayer hook.  Returns empty if not recorded.
+// If err is nil, returns "".
+func Layer(err error) string {
+	layer, _ := Value(err, errKeyLayer).(string)
+	return layer
+}
+
+// KindOf returns the kind associated with the error with WithKind.  Returns empty if not set.
+// Will not search causes.
+// If err is nil, returns "".
+func KindOf(err error) string {
+	kind, _ := Value(err, errKeyKind).(string)
+	return kind
+}
+
+// IsKind returns true if kind was associated with err, or any error in its chain, with
+// WithKind.  Like errors.Is, it searches both the chain of wrapped errors and the chain of
+// causes, so it matches kinds attached to inner errors, even if they were overridden by
+// outer wrappers.  If the chain branches, each branch is searched.
+// If err is nil, returns false.
+func IsKind(err error, kind string) bool {
+	for layer := err; layer != nil; layer = unwrapLayer(layer) {
+		switch t := layer.(type) {
+		case *errWithValue:
+			if t.key == errKeyKind && t.value == kind {
+				return true
+			}
+		case *errWithCause:
+			if IsKind(t.cause, kind) {
+				return true
+			}
+		default:
+			if x, ok := layer.(interface{ Unwrap() []error }); ok {
+				for _, branch := range x.Unwrap() {
+					if IsKind(branch, kind) {
+						return true
+					}
+				}
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// Op returns the most recent operation associated with the error with WithOp.  Returns
+// empty if not set.
+// If err is nil, returns "".
+func Op(err error) string {
+	op, _ := Value(err, errKeyOp).(string)
+	return op
+}
+
+// MetricLabels returns a low-cardinality set of labels describing err, suitable for labeling
+// error metrics, e.g. Prometheus counters:
+//
+//	errorsTotal.With(merry.MetricLabels(err)).Inc()
+//
+// The labels are:
+//
+//   - http_code: HTTPCode(err)
+//   - kind: KindOf(err)
+//   - operation: Op(err)
+//   - temporary: "true" if err, or any error it wraps, has a Temporary() method which returns
+//     true, like net.Error.  Otherwise "false".
+//
+// All the labels are always set, so every error has the same label names, which metrics
+// systems like Prometheus require.  Values which could have unbounded cardinality, like
+// messages, user messages, or IDs attached with WithValue, are never included.  Kinds and
+// operations should be drawn from a fixed set of names to keep their cardinality low.
+//
+// If err is nil, returns nil.
+func MetricLabels(err error) map[string]string {
+	if err == nil {
+		return nil
+	}
+
+	var temporary interface{ Temporary() bool }
+	isTemporary := errors.As(err, &temporary) && temporary.Temporary()
+
+	return map[string]string{
+		"http_code": strconv.Itoa(HTTPCode(err)),
+		"kind":      KindOf(err),
+		"operation": Op(err),
+		"temporary": strconv.FormatBool(isTemporary),
+	}
+}
+
+// Ops returns all the operations associated with the error with WithOp, most recent
+// (outermost) first.  Will not search causes.
+//
+// If err is nil, or has no operations, returns nil.
+func Ops(err error) []string {
+	var ops []string
+
+	for ; err != nil; err = unwrapLayer(err) {
+		if e, ok := err.(*errWithValue); ok && e.key == errKeyOp {
+			if op, ok := e.value.(string); ok {
+				ops = append(ops, op)
+			}
+		}
+	}
+
+	return ops
+}
+
+// UserMessage returns the end-user safe message.  Returns empty if not set.
+// If e is nil, returns "".
+func UserMessage(err error) string {
+	msg, _ := Value(err, errKeyUserMessage).(string)
+	return msg
+}
+
+// HasUserMessage returns true if a user message is attached to err, even if the
+// message is empty.  Will not search causes.
+func HasUserMessage(err error) bool {
+	return HasValue(err, errKeyUserMessage)
+}
+
+// UserMessageDeep is like UserMessage, but if the error has no user message, it searches the
+// chain of causes, and returns the first user message found.  User messages on outer errors
+// take precedence over the user messages of their causes.
+// If err is nil, returns "".
+func UserMessageDeep(err error) string {
+	for ; err != nil; err = Cause(err) {
+		if msg := UserMessage(err); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// LocalizedUserMessage returns the end-user message, translated into locale.  If the error
+// has a message key attached with WithUserMessageKey, and a localizer has been installed with
+// SetUserMessageLocalizer, the localizer is called with the key and locale.  If locale is empty,
+// the error's own locale is used (see WithLocale).
+//
+// If the error has no message key, there is no localizer, or the localizer returns an empty
+// string, this falls back on UserMessage(err).
+// If err is nil, returns "".
+func LocalizedUserMessage(err error, locale string) string {
+	localizer := UserMessageLocalizer()
+	if key, _ := Value(err, errKeyUserMessageKey).(string); key != "" && localizer != nil {
+		if locale == "" {
+			locale = Locale(err)
+		}
+		if msg := localizer(key, locale); msg != "" {
+			return msg
+		}
+	}
+	return UserMessage(err)
+}
+
+// Locale returns the locale associated with the error with WithLocale.  Returns empty
+// if not set.
+// If e is nil, returns "".
+func Locale(err error) string {
+	tag, _ := Value(err, errKeyLocale).(string)
+	return tag
+}
+
+// IsMerry returns true if err, or any error in its chain, was created or wrapped
+// by this package.
+// If err is nil, returns false.
+func IsMerry(err error) bool {
+	var merr interface {
+		error
+		isMerryError()
+	}
+	return errors.As(err, &merr)
+}
+
+// TopMerry returns the outermost error in err's chain which was created or wrapped by
+// this package, skipping any errors from other packages which wrap it.  The functions
+// in this package which don't search causes read the same values from err and TopMerry(err).
+//
+// If err is nil, or no error in its chain is a merry error, returns nil.
+func TopMerry(err error) error {
+	var merr interface {
+		error
+		isMerryError()
+	}
+	if errors.As(err, &merr) {
+		return merr
+	}
+	return nil
+}
+
+// As is a generic form of errors.As.  It finds the first error in err's chain which
+// matches type T, and returns it:
+//
+//	if pe, ok := merry.As[*fs.PathError](err); ok {
+//	  fmt.Println(pe.Path)
+//	}
+//
+// If no match is found, returns the zero value of T and false.
+func As[T error](err error) (T, bool) {
+	var target T
+	ok := errors.As(err, &target)
+	return target, ok
+}
+
+// Equal compares errors, ignoring their stacks.  It makes it possible to compare an error
+// against an expected error in tests, which fail with assert.Equal because the errors'
+// stacks differ.  Errors are equal if they have the same messages, and the same values
+// attached, like the HTTP code and user message, and their causes and suppressed errors
+// are equal, compared recursively the same way.  Values are compared with reflect.DeepEqual.
+// Stacks, callers attached with WithCaller, and the times attached with WithElapsedSince,
+// are ignored.  The concrete types of the errors are not compared.
+//
+// Returns true if both errors are nil.  See merrytest.AssertEqual.
+func Equal(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Error() != b.Error() {
+		return false
+	}
+	if !reflect.DeepEqual(comparableValues(a), comparableValues(b)) {
+		return false
+	}
+
+	sa, sb := Suppressed(a), Suppressed(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+	for i := range sa {
+		if !Equal(sa[i], sb[i]) {
+			return false
+		}
+	}
+
+	return Equal(Cause(a), Cause(b))
+}
+
+// comparableValues returns the values compared by Equal.
+func comparableValues(err error) map[interface{}]interface{} {
+	var values map[interface{}]interface{}
+	RangeValues(err, func(key, value interface{}) bool {
+		if _, ok := key.(namedStackKey); ok {
+			return true
+		}
+		switch key {
+		case errKeyStack, errKeyBoundaryStack, errKeyCaller, errKeySourceInMessage, errKeyElapsed, errKeySuppressed, errKeyHooked:
+			// errKeyHooked's value is the error itself, which includes its stack
+			return true
+		}
+		if values == nil {
+			values = map[interface{}]interface{}{}
+		}
+		values[key] = value
+		return true
+	})
+	return values
+}
+
+// SameKind returns true if a and b originate from the same code path, ignoring dynamic
+// data like IDs in overridden messages, attached values, and line numbers.  This is more
+// lenient than errors.Is, and is useful for grouping and deduplicating errors, or in tests.
+//
+// Two errors are the same kind if their root errors, i.e. the innermost errors in their chains
+// of wrappers, have the same message, and the top frames of their stacks are in the same function.
+// Causes are not compared.
+//
+// If both a and b are nil, returns true.
+func SameKind(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return rootMessage(a) == rootMessage(b) && topFunction(a) == topFunction(b)
+}
+
+// rootMessage returns the message of the innermost error in err's chain of wrappers.
+func rootMessage(err error) string {
+	for next := unwrapLayer(err); next != nil; next = unwrapLayer(next) {
+		err = next
+	}
+	return err.Error()
+}
+
+// topFunction returns the name of the function at the top of err's stack, or "" if
+// err has no stack.
+func topFunction(err error) string {
+	s := markedStack(err)
+	if len(s) == 0 {
+		return ""
+	}
+	frame, _ := resolveFrame(s[0])
+	return frame.Function
+}
+
+// Breadcrumbs returns the breadcrumbs recorded on the error with Breadcrumb, oldest
+// first.  Each is formatted as the note followed by the location where it was recorded.
+// Will not search causes.
+//
+// If err is nil, or has no breadcrumbs, returns nil.
+func Breadcrumbs(err error) []string {
+	var crumbs []string
+
+	for _, b := range breadcrumbs(err) {
+		crumbs = append(crumbs, b.String())
+	}
+
+	return crumbs
+}
+
+func breadcrumbs(err error) []breadcrumb {
+	var crumbs []breadcrumb
+
+	for ; err != nil; err = unwrapLayer(err) {
+		if e, ok := err.(*errWithValue); ok && e.key == errKeyBreadcrumb {
+			if b, ok := e.value.(breadcrumb); ok {
+				crumbs = append(crumbs, b)
+			}
+		}
+	}
+
+	// reverse, so the oldest is first
+	for i, j := 0, len(crumbs)-1; i < j; i, j = i+1, j-1 {
+		crumbs[i], crumbs[j] = crumbs[j], crumbs[i]
+	}
+
+	return crumbs
+}
+
+// Cause returns the cause of the argument.  If e is nil, or has no cause,
+// nil is returned.
+func Cause(err error) error {
+	var causer *errWithCause
+	if errors.As(err, &causer) {
+		return causer.cause
+	}
+	return nil
+}
+
+// Client returns a sanitized copy of err, which is safe to return to clients at the edge
+// of a service, while the original error is logged:
+//
+//	log.Print(merry.Details(err))
+//	return merry.Client(err)
+//
+// The copy's message is the user message, or the standard text for its HTTP code if err
+// has no user message.  It has err's HTTP code, as returned by HTTPCode(err), and the
+// values registered as public with RegisterPublicValue, like the user message.  Everything
+// else is stripped: the internal message, stacks, other values, and causes.  The copy
+// doesn't match err, or any of its causes, with errors.Is() or errors.As().
+//
+// If err is nil, returns nil.
+func Client(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := HTTPCode(err)
+	msg := UserMessage(err)
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	if msg == "" {
+		msg = http.StatusText(http.StatusInternalServerError)
+	}
+
+	client := Set(errors.New(msg), errKeyStack, nil)
+	if code != 0 {
+		client = Set(client, errKeyHTTPCode, code)
+	}
+	for _, key := range publicValueKeys() {
+		if v, ok := Lookup(err, key); ok {
+			client = Set(client, key, v)
+		}
+	}
+	return client
+}
+
+// ToJoin converts err into a flat list of errors, joined like errors.Join, for libraries
+// which expect joined errors.  The list contains err, followed by its cause, and the
+// cause's cause, and so on, followed by the errors suppressed by each of them (see
+// WithSuppressed), which are flattened the same way.  The joined error's message is their
+// messages, joined with newlines.  Errors.Is() and errors.As() match the joined errors in
+// go 1.20 and later.
+//
+// The conversion is lossy.  Only the messages of merry errors are preserved: each is
+// replaced with a plain error with the same message, without its values, stack, or
+// identity.  Errors in the list which weren't created or wrapped by this package are
+// preserved as-is.
+//
+// If err is nil, returns nil.
+func ToJoin(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &joinError{errs: flatten(err, nil)}
+}
+
+// flatten appends err, its causes, and their suppressed errors to errs.
+func flatten(err error, errs []error) []error {
+	var suppressed []error
+	for ; err != nil; err = Cause(err) {
+		if IsMerry(err) {
+			errs = append(errs, errors.New(err.Error()))
+		} else {
+			errs = append(errs, err)
+		}
+		suppressed = append(suppressed, Suppressed(err)...)
+	}
+	for _, s := range suppressed {
+		errs = flatten(s, errs)
+	}
+	return errs
+}
+
+// HasCause returns true if a cause is attached to err.  See Cause.
+func HasCause(err error) bool {
+	return Cause(err) != nil
+}
+
+// RegisteredDetails extracts details registered with RegisterDetailFunc from an error, and
+// returns them as a map.  Values may be nil.
+//
+// If err is nil or there are no registered details, nil is returned.
+func RegisteredDetails(err error) map[string]interface{} {
+	detailFields := registeredDetailFields()
+	if len(detailFields) == 0 || err == nil {
+		return nil
+	}
+
+	dets := map[string]interface{}{}
+
+	for label, f := range detailFields {
+		dets[label] = f(err)
+	}
+
+	return dets
 }
 
 // captureStack: return an error with a stack attached.  Stack will skip
@@ -322,13 +1734,117 @@ func captureStack(err error, skip int, force bool) error {
 		if stack := c.Callers(); len(stack) > 0 {
 			return Set(err, errKeyStack, stack)
 		}
-	case !captureStacks:
+	case !StackCaptureEnabled():
 		return err
 	}
 
-	s := make([]uintptr, MaxStackDepth())
-	length := runtime.Callers(2+skip, s[:])
-	return Set(err, errKeyStack, s[:length])
+	depth, ok := Value(err, errKeyMaxDepth).(int)
+	if !ok {
+		depth = MaxStackDepth()
+	}
+	return Set(err, errKeyStack, capturedValue(callersDepth(skip+1, depth)))
+}
+
+// capturedStack is a stack captured by this package, which contains the elidedFrames or
+// truncatedFrames markers.  Unlike the stacks attached with WithStack, Stack() and
+// Values() leave the markers out, so the stack is also kept without them, ready to be
+// returned without copying it.
+type capturedStack struct {
+	stack  interface{} // []uintptr, without the markers
+	marked []uintptr
+}
+
+// capturedValue returns the value to store a stack captured by this package as.  Stacks
+// with markers are stored as a capturedStack, and others as a plain []uintptr.
+func capturedValue(s []uintptr) interface{} {
+	for i, pc := range s {
+		if pc != elidedFrames && pc != truncatedFrames {
+			continue
+		}
+		stack := append(make([]uintptr, 0, len(s)-1), s[:i]...)
+		for _, pc := range s[i+1:] {
+			if pc != elidedFrames && pc != truncatedFrames {
+				stack = append(stack, pc)
+			}
+		}
+		return &capturedStack{stack: stack, marked: s}
+	}
+	return s
+}
+
+// elidedFrames marks where frames were removed from a stack.  See CaptureStackHeadTail.
+const elidedFrames uintptr = 0
+
+// truncatedFrames marks the end of a stack which was truncated at MaxStackDepth().
+// Like elidedFrames, it can't be confused with the program counter of a real frame.
+const truncatedFrames uintptr = 1
+
+// callers captures up to MaxStackDepth() frames, starting skip frames above the caller.
+// One extra frame is captured to detect whether the stack was truncated, in which case
+// the last frame is replaced with the truncatedFrames marker.
+//
+// If wrapper packages are registered, leading frames from wrapper packages are dropped.
+// See RegisterWrapperPackage.
+func callers(skip int) []uintptr {
+	return callersDepth(skip+1, MaxStackDepth())
+}
+
+// callersDepth is like callers, but captures up to depth frames.
+func callersDepth(skip, depth int) []uintptr {
+	if depth <= 0 {
+		return []uintptr{}
+	}
+
+	wrappers := wrapperPackageList()
+	extra := 0
+	if len(wrappers) > 0 {
+		// capture extra frames, to make up for the wrapper frames which are dropped
+		extra = wrapperFrames
+	}
+
+	s := make([]uintptr, depth+1+extra)
+	s = s[:runtime.Callers(2+skip, s)]
+	if len(wrappers) > 0 {
+		s = s[leadingWrapperFrames(s, wrappers):]
+	}
+	if len(s) > depth {
+		s[depth] = truncatedFrames
+		s = s[:depth+1]
+	}
+	return s
+}
+
+// captureHeadTail captures the entire stack, starting skip frames above the caller, but
+// only keeps the first head frames and last tail frames.  Like callers, leading frames
+// from wrapper packages are dropped first.
+func captureHeadTail(skip, head, tail int) []uintptr {
+	s := make([]uintptr, 64)
+	for {
+		length := runtime.Callers(2+skip, s)
+		if length < len(s) {
+			s = s[:length]
+			break
+		}
+		s = make([]uintptr, 2*len(s))
+	}
+	if wrappers := wrapperPackageList(); len(wrappers) > 0 {
+		s = s[leadingWrapperFrames(s, wrappers):]
+	}
+
+	if head < 1 {
+		head = 1
+	}
+	if tail < 0 {
+		tail = 0
+	}
+	if head+tail >= len(s) {
+		return s
+	}
+
+	trimmed := make([]uintptr, 0, head+tail+1)
+	trimmed = append(trimmed, s[:head]...)
+	trimmed = append(trimmed, elidedFrames)
+	return append(trimmed, s[len(s)-tail:]...)
 }
 
 // HasStack returns true if a stack is already attached to the err.
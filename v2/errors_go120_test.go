@@ -0,0 +1,21 @@
+//go:build go1.20
+
+package merry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorfSeveralWrappedErrors(t *testing.T) {
+	bang := New("bang")
+	sentinel := errors.New("sentinel")
+
+	// with several %w, the first is the cause
+	err := Errorf("boom: %w %w", bang, sentinel)
+	assert.EqualError(t, err, "boom: bang sentinel")
+	assert.Equal(t, bang, Cause(err))
+	assert.ErrorIs(t, err, sentinel)
+}
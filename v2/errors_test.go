@@ -1,11 +1,19 @@
 package merry
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"net/http"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -39,6 +47,19 @@ func TestErrorf(t *testing.T) {
 	// Printing errors wrapped by fmt.Print should include stacktrace (https://github.com/ansel1/merry/issues/26)
 	s := fmt.Sprintf("%+v", Errorf("boom: %w", New("bang")))
 	assert.Contains(t, s, "errors_test.go")
+
+	// the error wrapped with %w is the cause
+	bang := New("bang")
+	err = Errorf("boom: %w", bang)
+	assert.EqualError(t, err, "boom: bang")
+	assert.Equal(t, bang, Cause(err))
+	assert.ErrorIs(t, err, bang)
+	assert.Contains(t, Details(err), "Caused By: bang")
+	assert.Nil(t, Cause(Errorf("boom: %v", bang)))
+
+	// an explicit cause wins
+	sentinel := errors.New("sentinel")
+	assert.Equal(t, sentinel, Cause(Errorf("boom: %w", bang, WithCause(sentinel))))
 }
 
 func TestSentinel(t *testing.T) {
@@ -201,6 +222,21 @@ func TestAppendf(t *testing.T) {
 	err := Appendf(New("blue"), "big %s", WithHTTPCode(3), "red")
 	assert.Equal(t, 3, HTTPCode(err))
 	assert.EqualError(t, err, "blue: big red")
+
+	// the error wrapped with %w is the cause
+	bang := New("bang")
+	err = Appendf(New("blue"), "big %w", bang)
+	assert.EqualError(t, err, "blue: big bang")
+	assert.Equal(t, bang, Cause(err))
+	assert.ErrorIs(t, err, bang)
+
+	// an existing cause is kept
+	base := New("read failed", WithCause(io.EOF))
+	err = Appendf(base, "loading %w", io.ErrClosedPipe)
+	assert.EqualError(t, err, "read failed: loading io: read/write on closed pipe")
+	assert.Equal(t, io.EOF, Cause(err))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
 }
 
 func TestPrepend(t *testing.T) {
@@ -227,6 +263,136 @@ func TestPrependf(t *testing.T) {
 	err := Prependf(New("blue"), "big %s", WithHTTPCode(3), "red")
 	assert.Equal(t, 3, HTTPCode(err))
 	assert.EqualError(t, err, "big red: blue")
+
+	// the error wrapped with %w is the cause
+	bang := New("bang")
+	err = Prependf(New("blue"), "big %w", bang)
+	assert.EqualError(t, err, "big bang: blue")
+	assert.Equal(t, bang, Cause(err))
+	assert.ErrorIs(t, err, bang)
+
+	// an existing cause is kept
+	base := New("read failed", WithCause(io.EOF))
+	err = Prependf(base, "loading %w", io.ErrClosedPipe)
+	assert.EqualError(t, err, "loading io: read/write on closed pipe: read failed")
+	assert.Equal(t, io.EOF, Cause(err))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestPrependUnique(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, PrependUnique(nil, "retry"))
+
+	err := PrependUnique(New("blue"), "retry")
+	assert.EqualError(t, err, "retry: blue")
+
+	// prepending the same message again is a no-op
+	err = PrependUnique(err, "retry")
+	assert.EqualError(t, err, "retry: blue")
+
+	// but a different message is still prepended
+	err = PrependUnique(err, "retr", WithHTTPCode(3))
+	assert.EqualError(t, err, "retr: retry: blue")
+	assert.Equal(t, 3, HTTPCode(err))
+}
+
+func TestAppendUnique(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, AppendUnique(nil, "retry"))
+
+	err := AppendUnique(New("blue"), "retry")
+	assert.EqualError(t, err, "blue: retry")
+
+	// appending the same message again is a no-op
+	err = AppendUnique(err, "retry")
+	assert.EqualError(t, err, "blue: retry")
+
+	// but a different message is still appended
+	err = AppendUnique(err, "etry", WithHTTPCode(3))
+	assert.EqualError(t, err, "blue: retry: etry")
+	assert.Equal(t, 3, HTTPCode(err))
+}
+
+func TestTransform(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, Transform(nil, func(layer error) error { return layer }))
+
+	root := errors.New("boom")
+	cause := New("bam", WithValue("password", "secret"), WithValue("color", "blue"))
+	err := Wrap(root, WithValue("password", "hunter2"), WithHTTPCode(400), WithCause(cause), WithUserMessage("oops"))
+
+	// the identity transform preserves everything
+	var innermost []error
+	err2 := Transform(err, func(layer error) error {
+		if _, ok := layer.(interface{ isMerryError() }); !ok {
+			innermost = append(innermost, layer)
+		}
+		return layer
+	})
+	assert.EqualError(t, err2, "boom")
+	assert.Equal(t, Values(err), Values(err2))
+	assert.Equal(t, root, innermost[0])
+	assert.ErrorIs(t, err2, root)
+	assert.EqualError(t, Cause(err2), "bam")
+	assert.Equal(t, Values(cause), Values(Cause(err2)))
+
+	// drop values, including in causes
+	redacted := Transform(err, func(layer error) error {
+		if key, _, ok := LayerValue(layer); ok && key == "password" {
+			return nil
+		}
+		return layer
+	})
+	assert.Nil(t, Value(redacted, "password"))
+	assert.Nil(t, Value(Cause(redacted), "password"))
+	assert.Equal(t, "blue", Value(Cause(redacted), "color"))
+	assert.Equal(t, 400, HTTPCode(redacted))
+	assert.Equal(t, "oops", UserMessage(redacted))
+	assert.Equal(t, Stack(err), Stack(redacted))
+	assert.ErrorIs(t, redacted, root)
+
+	// the originals are unchanged
+	assert.Equal(t, "hunter2", Value(err, "password"))
+	assert.Equal(t, "secret", Value(cause, "password"))
+
+	// replace values
+	masked := Transform(err, func(layer error) error {
+		if key, _, ok := LayerValue(layer); ok && key == "password" {
+			return Set(layer, key, "****")
+		}
+		return layer
+	})
+	assert.Equal(t, "****", Value(masked, "password"))
+	assert.Equal(t, "****", Value(Cause(masked), "password"))
+
+	// replace the innermost error
+	replaced := Transform(err, func(layer error) error {
+		if layer == root {
+			return errors.New("bang")
+		}
+		return layer
+	})
+	assert.EqualError(t, replaced, "bang")
+	assert.Equal(t, 400, HTTPCode(replaced))
+	assert.NotErrorIs(t, replaced, root)
+
+	// dropping the innermost error keeps it
+	assert.EqualError(t, Transform(root, func(error) error { return nil }), "boom")
+}
+
+func TestLayerValue(t *testing.T) {
+	key, value, ok := LayerValue(Set(errors.New("boom"), "color", "red"))
+	assert.True(t, ok)
+	assert.Equal(t, "color", key)
+	assert.Equal(t, "red", value)
+
+	// only the outermost layer is inspected
+	_, _, ok = LayerValue(&UnwrapperError{Set(errors.New("boom"), "color", "red")})
+	assert.False(t, ok)
+
+	_, _, ok = LayerValue(nil)
+	assert.False(t, ok)
 }
 
 func TestValue(t *testing.T) {
@@ -281,6 +447,115 @@ func TestValues(t *testing.T) {
 	}, values)
 }
 
+func TestWithoutValue(t *testing.T) {
+	orig := New("boom", WithValue("color", "red"), WithValue("size", 5), WithUserMessage("bam"))
+	err := Wrap(orig, WithoutValue("color"), WithoutValue(errKeyUserMessage))
+
+	assert.Nil(t, Value(err, "color"))
+	_, ok := Lookup(err, "color")
+	assert.False(t, ok)
+	assert.Empty(t, UserMessage(err))
+	assert.Equal(t, 5, Value(err, "size"))
+
+	values := Values(err)
+	assert.NotContains(t, values, "color")
+	assert.NotContains(t, values, errKeyUserMessage)
+	assert.Equal(t, 5, values["size"])
+
+	RangeValues(err, func(key, value interface{}) bool {
+		assert.NotEqual(t, "color", key)
+		return true
+	})
+
+	assert.Equal(t, map[string]interface{}{"size": 5}, Fields(err))
+	assert.NotContains(t, Details(err), "bam")
+	assert.NotContains(t, Details(err), "red")
+
+	// the wrapped error is unchanged
+	assert.Equal(t, "red", Value(orig, "color"))
+
+	// a later value is visible again
+	err = Wrap(err, WithValue("color", "blue"))
+	assert.Equal(t, "blue", Value(err, "color"))
+
+	// groups left empty are removed
+	err = New("boom", WithGroup("db"), WithValue("query", "select"))
+	err = Wrap(err, WithGroup("db"), WithoutValue("query"))
+	assert.Nil(t, Fields(err))
+	assert.Nil(t, Values(WithoutValue("color").Wrap(errors.New("boom"), 0)))
+}
+
+func TestValuesPrecedence(t *testing.T) {
+	// a diamond: two branches wrap the same error, and set the same key to
+	// different values.  The leftmost branch wins.
+	base := New("base", WithValue("color", "red"), WithValue("size", 1))
+	left := Wrap(base, WithValue("color", "green"))
+	right := Wrap(base, WithValue("color", "blue"), WithValue("shape", "square"))
+	err := Wrap(&joinError{errs: []error{left, right}}, WithValue("weight", 5))
+
+	assert.Equal(t, "green", Value(err, "color"))
+	// values only set in the base are found through the leftmost branch
+	assert.Equal(t, 1, Value(err, "size"))
+	// values only set in later branches are still found
+	assert.Equal(t, "square", Value(err, "shape"))
+	assert.Equal(t, 5, Value(err, "weight"))
+
+	values := Values(err)
+	assert.Equal(t, "green", values["color"])
+	assert.Equal(t, 1, values["size"])
+	assert.Equal(t, "square", values["shape"])
+	assert.Equal(t, 5, values["weight"])
+
+	ranged := map[interface{}]interface{}{}
+	RangeValues(err, func(key, value interface{}) bool {
+		ranged[key] = value
+		return true
+	})
+	assert.Equal(t, values, ranged)
+
+	// swapping the branches swaps the precedence
+	err = &joinError{errs: []error{right, left}}
+	assert.Equal(t, "blue", Value(err, "color"))
+	assert.Equal(t, "blue", Values(err)["color"])
+
+	// branches are searched through errors from other packages
+	err = &UnwrapperError{&joinError{errs: []error{errors.New("plain"), &UnwrapperError{right}}}}
+	assert.Equal(t, "blue", Value(err, "color"))
+	assert.Equal(t, "blue", Values(err)["color"])
+
+	// a value removed in the leftmost branch masks the values in later branches
+	masked := Wrap(base, WithoutValue("color"))
+	err = &joinError{errs: []error{masked, right}}
+	_, ok := Lookup(err, "color")
+	assert.False(t, ok)
+	assert.False(t, HasValue(err, "color"))
+	assert.NotContains(t, Values(err), "color")
+	RangeValues(err, func(key, value interface{}) bool {
+		assert.NotEqual(t, "color", key)
+		return true
+	})
+	assert.Equal(t, "square", Value(err, "shape"))
+}
+
+func TestFields(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, Fields(nil))
+
+	// no fields -> nil
+	assert.Nil(t, Fields(New("boom", WithHTTPCode(4))))
+
+	// only includes string keys, and the most recent value
+	type colorKey int
+	err := New("boom", WithValue("color", "red"), WithValue(colorKey(1), "blue"), WithHTTPCode(4))
+	err = &UnwrapperError{err}
+	err = Wrap(err, WithValue("color", "green"), WithValue("size", 5))
+	assert.Equal(t, map[string]interface{}{"color": "green", "size": 5}, Fields(err))
+
+	// will not search causes
+	err = New("boom", WithCause(New("bam", WithValue("color", "red"))))
+	assert.Nil(t, Fields(err))
+}
+
 func BenchmarkValues(b *testing.B) {
 	// create an error chain with a few values attached, and a non-merry error
 	// in the middle.
@@ -288,6 +563,7 @@ func BenchmarkValues(b *testing.B) {
 	err = &UnwrapperError{err}
 	err = Wrap(err, WithValue("color", "red"))
 
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -295,6 +571,64 @@ func BenchmarkValues(b *testing.B) {
 	}
 }
 
+func TestRangeValues(t *testing.T) {
+	collect := func(err error) map[interface{}]interface{} {
+		values := map[interface{}]interface{}{}
+		RangeValues(err, func(key, value interface{}) bool {
+			_, dup := values[key]
+			assert.False(t, dup, "key %v was visited twice", key)
+			values[key] = value
+			return true
+		})
+		return values
+	}
+
+	assert.Empty(t, collect(nil))
+
+	err := New("boom", WithUserMessage("bam"), WithHTTPCode(4))
+	err = &UnwrapperError{err}
+	err = Wrap(err, WithValue("color", "red"), WithHTTPCode(5))
+
+	// the last value attached wins
+	values := collect(err)
+	assert.Equal(t, "red", values["color"])
+	assert.Equal(t, 5, values[errKeyHTTPCode])
+	assert.Equal(t, "bam", values[errKeyUserMessage])
+	assert.Equal(t, Values(err), values)
+
+	// stops when fn returns false
+	var n int
+	RangeValues(err, func(key, value interface{}) bool {
+		n++
+		return false
+	})
+	assert.Equal(t, 1, n)
+
+	// stacks with markers are passed without them, and without allocating
+	err = New("boom", CaptureStackHeadTail(1, 1))
+	assert.Equal(t, Stack(err), collect(err)[errKeyStack])
+	assert.Zero(t, testing.AllocsPerRun(10, func() {
+		RangeValues(err, func(key, value interface{}) bool {
+			return true
+		})
+	}))
+}
+
+func BenchmarkRangeValues(b *testing.B) {
+	err := New("boom", WithUserMessage("bam"), WithHTTPCode(4))
+	err = &UnwrapperError{err}
+	err = Wrap(err, WithValue("color", "red"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		RangeValues(err, func(key, value interface{}) bool {
+			return true
+		})
+	}
+}
+
 func TestStack(t *testing.T) {
 	// nil -> nil
 	assert.Nil(t, Stack(nil))
@@ -313,6 +647,109 @@ func TestStack(t *testing.T) {
 	assert.NotEmpty(t, Stack(err))
 }
 
+func TestStacks(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, Stacks(nil))
+
+	// error without a stack
+	assert.Nil(t, Stacks(errors.New("boom")))
+	assert.Nil(t, Stacks(New("boom", NoCaptureStack())))
+
+	// single stack
+	err := New("boom")
+	assert.Equal(t, [][]uintptr{Stack(err)}, Stacks(err))
+
+	// wrapping doesn't duplicate the stack
+	err = Wrap(err, WithHTTPCode(5))
+	assert.Len(t, Stacks(err), 1)
+
+	// a recaptured stack is returned before the original stack
+	origStack := Stack(err)
+	err = Wrap(&UnwrapperError{err}, CaptureStack(false))
+	assert.Equal(t, [][]uintptr{Stack(err), origStack}, Stacks(err))
+
+	// cause stacks come last
+	cause := New("bam")
+	err = New("boom", WithCause(cause))
+	assert.Equal(t, [][]uintptr{Stack(err), Stack(cause)}, Stacks(err))
+
+	// overlapping stacks are merged into the longer stack
+	deep := New("bam", CaptureStack(false))
+	s := Stack(deep)
+	shallow := New("boom", WithStack(s[1:]), WithCause(deep))
+	assert.Equal(t, [][]uintptr{s}, Stacks(shallow))
+	shallow = New("boom", WithStack(s[:2:2]), WithCause(deep))
+	assert.Equal(t, [][]uintptr{s}, Stacks(shallow))
+	truncated := append(s[:2:2], truncatedFrames)
+	assert.Equal(t, [][]uintptr{s}, Stacks(New("boom", WithStack(truncated), WithCause(deep))))
+	assert.Equal(t, [][]uintptr{s}, Stacks(New("boom", WithStack(s), WithCause(New("bam", WithStack(truncated))))))
+}
+
+func TestDetailsMergesStacks(t *testing.T) {
+	// the error and its cause have the same stack
+	cause := New("bam")
+	err := Wrap(errors.New("boom"), WithStack(Stack(cause)), WithCause(cause))
+
+	d := Details(err)
+	assert.Equal(t, 1, strings.Count(d, Stacktrace(err)), d)
+	assert.Contains(t, d, "Caused By: bam\n\n(stack included above)")
+
+	// omitted stacks aren't mentioned
+	assert.NotContains(t, DetailsStable(err, OmitStack), "stack included above")
+}
+
+func TestRequire(t *testing.T) {
+	assert.NoError(t, Require(true, "boom"))
+
+	_, _, rl, _ := runtime.Caller(0)
+	err := Require(false, "limit must be positive, was %d", -1)
+	assert.EqualError(t, err, "limit must be positive, was -1")
+	assert.Equal(t, "limit must be positive, was -1", UserMessage(err))
+	assert.Equal(t, 400, HTTPCode(err))
+	_, l := Location(err)
+	assert.Equal(t, rl+1, l)
+
+	// wrappers override the defaults
+	err = Require(false, "bad %s", "input", WithHTTPCode(422))
+	assert.EqualError(t, err, "bad input")
+	assert.Equal(t, 422, HTTPCode(err))
+}
+
+func TestRequireNotNil(t *testing.T) {
+	assert.NoError(t, RequireNotNil(5, "count"))
+	assert.NoError(t, RequireNotNil(&struct{}{}, "user"))
+
+	var p *struct{}
+	var m map[string]string
+
+	for _, v := range []interface{}{nil, p, m} {
+		err := RequireNotNil(v, "user")
+		assert.EqualError(t, err, "user must not be nil")
+		assert.Equal(t, "user must not be nil", UserMessage(err))
+		assert.Equal(t, 400, HTTPCode(err))
+	}
+
+	_, _, rl, _ := runtime.Caller(0)
+	err := RequireNotNil(nil, "user", WithHTTPCode(422))
+	assert.Equal(t, 422, HTTPCode(err))
+	_, l := Location(err)
+	assert.Equal(t, rl+1, l)
+}
+
+func TestNilWrappers(t *testing.T) {
+	var nilFunc WrapperFunc
+
+	err := Wrap(errors.New("boom"), nil, WithHTTPCode(5), nilFunc)
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 5, HTTPCode(err))
+
+	err = New("boom", nil)
+	assert.EqualError(t, err, "boom")
+
+	err = Apply(err, nil, WithUserMessage("oops"))
+	assert.Equal(t, "oops", UserMessage(err))
+}
+
 func TestHTTPCode(t *testing.T) {
 	// nil -> 200
 	assert.Equal(t, 200, HTTPCode(nil))
@@ -328,6 +765,59 @@ func TestHTTPCode(t *testing.T) {
 	err = &UnwrapperError{err}
 	err = Wrap(err, WithUserMessage("yikes"))
 	assert.Equal(t, 404, HTTPCode(err))
+
+	// codes can be derived from kinds, but explicit codes win
+	RegisterKindHTTPCode("NotFound", 404)
+	defer RegisterKindHTTPCode("NotFound", 0)
+	assert.Equal(t, 404, HTTPCode(New("boom", WithKind("NotFound"))))
+	assert.Equal(t, 410, HTTPCode(New("boom", WithKind("NotFound"), WithHTTPCode(410))))
+	assert.Equal(t, 500, HTTPCode(New("boom", WithKind("Conflict"))))
+
+	// with defaulting off, errors without a code map to 0
+	defer SetHTTPCodeDefaulting(true)
+	SetHTTPCodeDefaulting(false)
+	assert.Equal(t, 0, HTTPCode(errors.New("boom")))
+	assert.Equal(t, 200, HTTPCode(nil))
+	assert.Equal(t, 404, HTTPCode(err))
+}
+
+func TestAttachedHTTPCode(t *testing.T) {
+	_, ok := AttachedHTTPCode(nil)
+	assert.False(t, ok)
+	_, ok = AttachedHTTPCode(errors.New("boom"))
+	assert.False(t, ok)
+
+	code, ok := AttachedHTTPCode(New("boom", WithHTTPCode(404)))
+	assert.True(t, ok)
+	assert.Equal(t, 404, code)
+
+	// kinds don't attach codes
+	RegisterKindHTTPCode("NotFound", 404)
+	defer RegisterKindHTTPCode("NotFound", 0)
+	_, ok = AttachedHTTPCode(New("boom", WithKind("NotFound")))
+	assert.False(t, ok)
+}
+
+func TestHTTPCodeDeep(t *testing.T) {
+	// nil -> 200
+	assert.Equal(t, 200, HTTPCodeDeep(nil))
+
+	// default to 500
+	assert.Equal(t, 500, HTTPCodeDeep(errors.New("boom")))
+
+	cause := New("not found", WithHTTPCode(404))
+	err := New("high level", WithCause(New("mid level", WithCause(cause))))
+	assert.Equal(t, 500, HTTPCode(err))
+	assert.Equal(t, 404, HTTPCodeDeep(err))
+
+	// outer codes take precedence
+	assert.Equal(t, 503, HTTPCodeDeep(Wrap(err, WithHTTPCode(503))))
+
+	// including codes registered for outer kinds
+	RegisterKindHTTPCode("Unavailable", 503)
+	defer RegisterKindHTTPCode("Unavailable", 0)
+	assert.Equal(t, 503, HTTPCodeDeep(Wrap(err, WithKind("Unavailable"))))
+	assert.Equal(t, 404, HTTPCodeDeep(New("high level", WithCause(Wrap(cause, WithKind("Conflict"))))))
 }
 
 func TestUserMessage(t *testing.T) {
@@ -347,6 +837,46 @@ func TestUserMessage(t *testing.T) {
 	assert.Equal(t, "red", UserMessage(err))
 }
 
+func TestEmptyMessage(t *testing.T) {
+	// without a user message, the message stays empty
+	assert.EqualError(t, New(""), "")
+
+	// the user message is the fallback for empty messages
+	err := New("", WithUserMessage("bang"))
+	assert.EqualError(t, err, "bang")
+	assert.EqualError(t, Wrap(err, WithHTTPCode(404)), "bang")
+	assert.EqualError(t, Wrap(errors.New(""), WithUserMessagef("%s", "bang")), "bang")
+	assert.EqualError(t, Wrap(&UnwrapperError{New("")}, WithUserMessage("bang")), "bang")
+
+	// messages which aren't empty take precedence
+	assert.EqualError(t, New("boom", WithUserMessage("bang")), "boom")
+	assert.EqualError(t, Wrap(err, WithMessage("boom")), "boom")
+	assert.EqualError(t, Wrap(New("", WithUserMessage("")), WithUserMessage("bang")), "bang")
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, Breadcrumbs(nil))
+
+	// no breadcrumbs -> nil
+	assert.Nil(t, Breadcrumbs(New("boom")))
+
+	_, _, rl, _ := runtime.Caller(0)
+	err := New("boom", Breadcrumb("created"))
+	err = Wrap(err, Breadcrumb("passed"))
+
+	// the message is not changed
+	assert.EqualError(t, err, "boom")
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("created: github.com/ansel1/merry/v2.TestBreadcrumbs (errors_test.go:%d)", rl+1),
+		fmt.Sprintf("passed: github.com/ansel1/merry/v2.TestBreadcrumbs (errors_test.go:%d)", rl+2),
+	}, Breadcrumbs(err))
+
+	// will not search causes
+	assert.Nil(t, Breadcrumbs(New("bam", WithCause(err))))
+}
+
 func TestCause(t *testing.T) {
 	// nil -> nil
 	assert.Nil(t, Cause(nil))
@@ -364,6 +894,703 @@ func TestCause(t *testing.T) {
 	assert.Nil(t, Cause(err))
 }
 
+func TestHasUserMessage(t *testing.T) {
+	assert.False(t, HasUserMessage(nil))
+	assert.False(t, HasUserMessage(New("boom")))
+	assert.True(t, HasUserMessage(New("boom", WithUserMessage("bam"))))
+
+	// set, but empty
+	assert.True(t, HasUserMessage(New("boom", WithUserMessage(""))))
+
+	// will not search causes
+	assert.False(t, HasUserMessage(New("boom", WithCause(New("bam", WithUserMessage("bam"))))))
+}
+
+func TestHasValue(t *testing.T) {
+	assert.False(t, HasValue(nil, "color"))
+	assert.False(t, HasValue(New("boom"), "color"))
+	assert.True(t, HasValue(New("boom", WithValue("color", "red")), "color"))
+	assert.True(t, HasValue(New("boom", WithValue("color", nil)), "color"))
+	assert.False(t, HasValue(New("boom", WithValue("color", "red"), WithoutValue("color")), "color"))
+}
+
+func TestHasCause(t *testing.T) {
+	assert.False(t, HasCause(nil))
+	assert.False(t, HasCause(New("boom")))
+	assert.True(t, HasCause(New("boom", WithCause(errors.New("bam")))))
+}
+
+func TestUserMessageDeep(t *testing.T) {
+	// nil -> empty
+	assert.Empty(t, UserMessageDeep(nil))
+	assert.Empty(t, UserMessageDeep(New("boom")))
+
+	cause := New("bang", WithUserMessage("not found"))
+	err := New("boom", WithCause(New("bam", WithCause(cause))))
+	assert.Empty(t, UserMessage(err))
+	assert.Equal(t, "not found", UserMessageDeep(err))
+
+	// outer user messages take precedence
+	err = Wrap(err, WithUserMessage("try again"))
+	assert.Equal(t, "try again", UserMessageDeep(err))
+}
+
+func TestLocalizedUserMessage(t *testing.T) {
+	defer SetUserMessageLocalizer(nil)
+
+	// nil -> empty
+	assert.Empty(t, LocalizedUserMessage(nil, "fr-FR"))
+
+	// without a localizer, falls back on the user message
+	err := New("boom", WithUserMessage("hello"), WithUserMessageKey("greeting"))
+	assert.Equal(t, "hello", LocalizedUserMessage(err, "fr-FR"))
+
+	SetUserMessageLocalizer(func(key, locale string) string {
+		if key == "greeting" && locale == "fr-FR" {
+			return "bonjour"
+		}
+		return ""
+	})
+	assert.NotNil(t, UserMessageLocalizer())
+
+	assert.Equal(t, "bonjour", LocalizedUserMessage(err, "fr-FR"))
+
+	// falls back on the user message if the localizer can't translate the key
+	assert.Equal(t, "hello", LocalizedUserMessage(err, "de-DE"))
+
+	// defaults to the error's locale
+	assert.Equal(t, "hello", LocalizedUserMessage(err, ""))
+	assert.Equal(t, "bonjour", LocalizedUserMessage(Wrap(err, WithLocale("fr-FR")), ""))
+
+	// no key -> user message
+	assert.Equal(t, "hello", LocalizedUserMessage(New("boom", WithUserMessage("hello")), "fr-FR"))
+}
+
+func TestLocale(t *testing.T) {
+	// nil -> empty
+	assert.Empty(t, Locale(nil))
+
+	// default to empty
+	assert.Empty(t, Locale(New("boom")))
+
+	// set with wrapper
+	assert.Equal(t, "fr-FR", Locale(New("boom", WithLocale("fr-FR"))))
+
+	// works when value is deep in stack
+	err := New("bam", WithLocale("fr-FR"))
+	err = &UnwrapperError{err}
+	err = Wrap(err, WithHTTPCode(404))
+	assert.Equal(t, "fr-FR", Locale(err))
+}
+
+func TestHTTPHeaders(t *testing.T) {
+	// nil -> nil
+	assert.Nil(t, HTTPHeaders(nil))
+	assert.Nil(t, HTTPHeaders(New("boom")))
+
+	h := http.Header{"Www-Authenticate": {"Basic"}}
+	err := New("boom", WithHTTPHeaders(h))
+	assert.Equal(t, h, HTTPHeaders(err))
+
+	// the attached headers are copies
+	h.Set("Www-Authenticate", "Bearer")
+	HTTPHeaders(err).Set("Www-Authenticate", "Bearer")
+	assert.Equal(t, "Basic", HTTPHeaders(err).Get("Www-Authenticate"))
+
+	// headers are merged, newer values replace older values
+	err = Wrap(err, WithHTTPHeaders(http.Header{"Www-Authenticate": {"Bearer"}, "Retry-After": {"5"}}))
+	assert.Equal(t, http.Header{"Www-Authenticate": {"Bearer"}, "Retry-After": {"5"}}, HTTPHeaders(err))
+
+	assert.Contains(t, Details(err), "HTTP Headers: map[")
+}
+
+func TestRetryAfter(t *testing.T) {
+	// nil -> 0, false
+	d, ok := RetryAfter(nil)
+	assert.False(t, ok)
+	assert.Zero(t, d)
+
+	_, ok = RetryAfter(New("boom"))
+	assert.False(t, ok)
+
+	d, ok = RetryAfter(New("boom", WithRetryAfter(5*time.Second)))
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+}
+
+func TestKindOf(t *testing.T) {
+	// nil -> empty
+	assert.Empty(t, KindOf(nil))
+	assert.Empty(t, KindOf(New("boom")))
+
+	err := New("boom", WithKind("NotFound"))
+	assert.Equal(t, "NotFound", KindOf(err))
+	assert.Contains(t, Details(err), "Kind: NotFound")
+
+	// outer kinds override inner kinds
+	assert.Equal(t, "Internal", KindOf(Wrap(err, WithKind("Internal"))))
+
+	// causes are not searched
+	assert.Empty(t, KindOf(New("bang", WithCause(err))))
+}
+
+func TestIsKind(t *testing.T) {
+	// nil -> false
+	assert.False(t, IsKind(nil, "NotFound"))
+	assert.False(t, IsKind(New("boom"), "NotFound"))
+
+	err := New("boom", WithKind("NotFound"))
+	assert.True(t, IsKind(err, "NotFound"))
+	assert.False(t, IsKind(err, "Conflict"))
+
+	// overridden kinds still match
+	err = Wrap(err, WithKind("Internal"))
+	assert.True(t, IsKind(err, "NotFound"))
+	assert.True(t, IsKind(err, "Internal"))
+
+	// searches through foreign wrappers and causes
+	assert.True(t, IsKind(fmt.Errorf("wrapped: %w", err), "NotFound"))
+	assert.True(t, IsKind(New("bang", WithCause(err)), "NotFound"))
+
+	// searches each branch of errors wrapping several errors
+	joined := Wrap(&joinError{errs: []error{New("first", WithKind("Conflict")), err}})
+	assert.True(t, IsKind(joined, "Conflict"))
+	assert.True(t, IsKind(joined, "NotFound"))
+	assert.False(t, IsKind(joined, "Unavailable"))
+	assert.True(t, IsKind(fmt.Errorf("wrapped: %w", joined), "NotFound"))
+	assert.True(t, IsKind(New("bang", WithCause(joined)), "NotFound"))
+
+	// but not suppressed errors
+	assert.False(t, IsKind(New("bang", WithSuppressed(err)), "NotFound"))
+}
+
+func TestOps(t *testing.T) {
+	// nil -> empty
+	assert.Empty(t, Op(nil))
+	assert.Nil(t, Ops(nil))
+	assert.Nil(t, Ops(New("boom")))
+
+	err := New("boom", WithOp("query"))
+	err = fmt.Errorf("wrapped: %w", err)
+	err = Wrap(err, WithOp("GetUser"))
+	err = Wrap(err, WithHTTPCode(404), WithOp("HandleRequest"))
+
+	assert.Equal(t, "HandleRequest", Op(err))
+	assert.Equal(t, []string{"HandleRequest", "GetUser", "query"}, Ops(err))
+	assert.Contains(t, Details(err), "Operation: HandleRequest")
+
+	// causes are not searched
+	assert.Nil(t, Ops(New("bang", WithCause(err))))
+}
+
+func TestIsMerry(t *testing.T) {
+	// nil -> false
+	assert.False(t, IsMerry(nil))
+
+	// plain errors are not merry errors
+	assert.False(t, IsMerry(errors.New("boom")))
+
+	assert.True(t, IsMerry(New("boom")))
+	assert.True(t, IsMerry(Apply(errors.New("boom"), WithHTTPCode(4))))
+	assert.True(t, IsMerry(&errWithCause{err: errors.New("boom"), cause: errors.New("bam")}))
+
+	// works when the merry error is deeper in the chain
+	assert.True(t, IsMerry(&UnwrapperError{New("boom")}))
+	assert.True(t, IsMerry(fmt.Errorf("bam: %w", New("boom"))))
+}
+
+func TestDebugChecks(t *testing.T) {
+	defer SetDebugChecks(false)
+
+	replace := WrapperFunc(func(err error, _ int) error {
+		return errors.New("replaced")
+	})
+	drop := WrapperFunc(func(err error, _ int) error {
+		return nil
+	})
+
+	// off by default
+	assert.False(t, DebugChecks())
+	assert.NotPanics(t, func() {
+		Wrap(errors.New("boom"), replace)
+		Wrap(errors.New("boom"), drop)
+	})
+
+	SetDebugChecks(true)
+	assert.True(t, DebugChecks())
+
+	assert.PanicsWithValue(t, "merry: wrapper merry.WrapperFunc returned an error which doesn't wrap error: boom", func() {
+		Wrap(errors.New("boom"), replace)
+	})
+	assert.PanicsWithValue(t, "merry: wrapper merry.WrapperFunc returned nil when wrapping error: boom", func() {
+		Apply(errors.New("boom"), drop)
+	})
+
+	// the wrappers in this package pass
+	assert.NotPanics(t, func() {
+		Wrap(errors.New("boom"), WithHTTPCode(404), WithUserMessage("not found"), WithCause(errors.New("bang")),
+			WithGroup("db"), WithValue("query", "select"), WithoutValue("color"))
+	})
+}
+
+func TestClient(t *testing.T) {
+	assert.Nil(t, Client(nil))
+
+	type secretKey struct{}
+	type publicKey struct{}
+	RegisterPublicValue(publicKey{})
+
+	cause := errors.New("connection refused")
+	err := New("db query failed: select * from users",
+		WithHTTPCode(503),
+		WithUserMessage("Try again later"),
+		WithRetryAfter(time.Minute),
+		WithHint("Wait a minute"),
+		WithValue(secretKey{}, "password"),
+		WithValue(publicKey{}, "request-1"),
+		WithCause(cause),
+	)
+
+	client := Client(err)
+	assert.EqualError(t, client, "Try again later")
+	assert.True(t, IsMerry(client))
+	assert.Equal(t, 503, HTTPCode(client))
+	assert.Equal(t, "Try again later", UserMessage(client))
+	d, _ := RetryAfter(client)
+	assert.Equal(t, time.Minute, d)
+	assert.Equal(t, "request-1", Value(client, publicKey{}))
+	assert.Equal(t, "Wait a minute", Hint(client))
+
+	// internal information is stripped
+	assert.Nil(t, Value(client, secretKey{}))
+	assert.Empty(t, Stack(client))
+	assert.Nil(t, Cause(client))
+	assert.NotErrorIs(t, client, cause)
+	assert.NotContains(t, Details(client), "db query failed")
+
+	// wrapping doesn't capture a stack
+	assert.Empty(t, Stack(Wrap(client)))
+
+	// without a user message, the message is the status text
+	assert.EqualError(t, Client(New("boom", WithHTTPCode(404))), "Not Found")
+	assert.EqualError(t, Client(errors.New("boom")), "Internal Server Error")
+	assert.Equal(t, 500, HTTPCode(Client(errors.New("boom"))))
+}
+
+func TestToJoin(t *testing.T) {
+	assert.Nil(t, ToJoin(nil))
+
+	rootCause := errors.New("disk full")
+	closeErr := New("close failed", WithCause(io.ErrClosedPipe))
+	err := New("write failed", WithCause(rootCause), WithSuppressed(closeErr), WithHTTPCode(500))
+	err = Wrap(err, WithCause(New("save failed", WithCause(rootCause))))
+
+	joined := ToJoin(err)
+	require.Implements(t, (*interface{ Unwrap() []error })(nil), joined)
+	errs := joined.(interface{ Unwrap() []error }).Unwrap()
+
+	assert.EqualError(t, joined, "write failed\nsave failed\ndisk full\nclose failed\nio: read/write on closed pipe")
+	require.Len(t, errs, 5)
+
+	// merry errors are replaced with plain errors
+	assert.False(t, IsMerry(errs[0]))
+	assert.Empty(t, Stack(errs[0]))
+
+	// other errors are preserved as-is
+	assert.Equal(t, rootCause, errs[2])
+	assert.Equal(t, io.ErrClosedPipe, errs[4])
+}
+
+type temporaryError struct {
+	temporary bool
+}
+
+func (e *temporaryError) Error() string {
+	return "temporary"
+}
+
+func (e *temporaryError) Temporary() bool {
+	return e.temporary
+}
+
+func TestHTTPStatusText(t *testing.T) {
+	assert.Equal(t, "OK", HTTPStatusText(nil))
+	assert.Equal(t, "Internal Server Error", HTTPStatusText(errors.New("boom")))
+	assert.Equal(t, "Not Found", HTTPStatusText(New("boom", WithHTTPCode(404))))
+	assert.Equal(t, "", HTTPStatusText(New("boom", WithHTTPCode(999))))
+}
+
+func TestMetricLabels(t *testing.T) {
+	assert.Nil(t, MetricLabels(nil))
+
+	assert.Equal(t, map[string]string{
+		"http_code": "500",
+		"kind":      "",
+		"operation": "",
+		"temporary": "false",
+	}, MetricLabels(New("boom")))
+
+	err := New("user 1234 not found", WithHTTPCode(404), WithKind("NotFound"), WithOp("GetUser"), WithValue("user", "1234"), WithUserMessage("user 1234 not found"))
+	assert.Equal(t, map[string]string{
+		"http_code": "404",
+		"kind":      "NotFound",
+		"operation": "GetUser",
+		"temporary": "false",
+	}, MetricLabels(err))
+
+	assert.Equal(t, "true", MetricLabels(Wrap(&temporaryError{temporary: true}))["temporary"])
+	assert.Equal(t, "false", MetricLabels(Wrap(&temporaryError{temporary: false}))["temporary"])
+}
+
+func TestEqual(t *testing.T) {
+	assert.True(t, Equal(nil, nil))
+	assert.False(t, Equal(nil, New("boom")))
+	assert.False(t, Equal(New("boom"), nil))
+
+	// stacks are ignored
+	newErr := func() error {
+		return New("boom", WithHTTPCode(404), WithUserMessage("not found"), AddStack(), WithNamedStack("x", nil))
+	}
+	e1 := newErr()
+	e2 := newErr()
+	assert.NotEqual(t, e1, e2)
+	assert.True(t, Equal(e1, e2))
+	assert.True(t, Equal(New("boom", WithHTTPCode(404), WithUserMessage("not found"), NoCaptureStack()), e1))
+
+	// messages, values, and causes are compared
+	assert.False(t, Equal(e1, New("bang", WithHTTPCode(404), WithUserMessage("not found"))))
+	assert.False(t, Equal(e1, New("boom", WithHTTPCode(500), WithUserMessage("not found"))))
+	assert.False(t, Equal(e1, Wrap(e1, WithValue("color", "red"))))
+	assert.True(t, Equal(Wrap(e1, WithCause(errors.New("io"))), Wrap(e2, WithCause(New("io")))))
+	assert.False(t, Equal(Wrap(e1, WithCause(errors.New("io"))), Wrap(e2, WithCause(errors.New("eof")))))
+	assert.False(t, Equal(Wrap(e1, WithCause(errors.New("io"))), e2))
+	assert.True(t, Equal(Wrap(e1, WithSuppressed(New("close"))), Wrap(e2, WithSuppressed(New("close")))))
+	assert.False(t, Equal(Wrap(e1, WithSuppressed(New("close"))), Wrap(e2, WithSuppressed(New("flush")))))
+
+	// elapsed times are ignored
+	assert.True(t, Equal(Wrap(e1, WithElapsedSince(time.Now().Add(-time.Hour))), Wrap(e2, WithElapsedSince(time.Now()))))
+}
+
+func TestTopMerry(t *testing.T) {
+	assert.Nil(t, TopMerry(nil))
+	assert.Nil(t, TopMerry(errors.New("boom")))
+
+	err := New("boom", WithHTTPCode(404))
+	assert.Equal(t, err, TopMerry(err))
+
+	// skips foreign wrappers
+	wrapped := fmt.Errorf("bam: %w", &UnwrapperError{err})
+	assert.Equal(t, err, TopMerry(wrapped))
+	assert.Equal(t, 404, HTTPCode(TopMerry(wrapped)))
+}
+
+func TestWrapAll(t *testing.T) {
+	assert.Nil(t, WrapAll(nil))
+
+	e1, e2 := errors.New("boom"), errors.New("bang")
+	errs := []error{e1, nil, e2}
+
+	_, _, rl, _ := runtime.Caller(0)
+	wrapped := WrapAll(errs, WithHTTPCode(404))
+	require.Len(t, wrapped, 3)
+
+	// the input is unchanged
+	assert.Equal(t, []error{e1, nil, e2}, errs)
+
+	assert.Nil(t, wrapped[1])
+	for i, err := range []error{e1, e2} {
+		w := wrapped[i*2]
+		assert.ErrorIs(t, w, err)
+		assert.Equal(t, 404, HTTPCode(w))
+		_, l := Location(w)
+		assert.Equal(t, rl+1, l)
+	}
+}
+
+func TestWrapContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	assert.Nil(t, WrapContext(ctx, nil))
+
+	// live contexts are ignored
+	_, _, rl, _ := runtime.Caller(0)
+	err := WrapContext(ctx, errors.New("boom"), WithHTTPCode(404))
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 404, HTTPCode(err))
+	assert.Nil(t, Cause(err))
+	_, l := Location(err)
+	assert.Equal(t, rl+1, l)
+
+	cancel()
+
+	err = WrapContext(ctx, errors.New("boom"), WithHTTPCode(404))
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 404, HTTPCode(err))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, context.Canceled, Cause(err))
+
+	// errors which already match are left alone
+	err = WrapContext(ctx, fmt.Errorf("boom: %w", context.Canceled))
+	assert.Nil(t, Cause(err))
+
+	// an existing cause is kept
+	err = WrapContext(ctx, New("boom", WithCause(io.EOF)))
+	assert.Equal(t, io.EOF, Cause(err))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMessage(t *testing.T) {
+	assert.Empty(t, Message(nil))
+	assert.Equal(t, "boom", Message(errors.New("boom")))
+	assert.Equal(t, "boom", Message(New("boom", WithCause(errors.New("io")))))
+	assert.Equal(t, "big: boom", Message(Prepend(errors.New("boom"), "big")))
+	assert.Equal(t, "bang", Message(Wrap(errors.New("boom"), WithMessage("bang"), WithHTTPCode(404))))
+
+	// verbose details aren't included
+	err := New("boom", WithCause(errors.New("io")), AlwaysVerbose())
+	assert.NotEqual(t, "boom", err.Error())
+	assert.Equal(t, "boom", Message(err))
+
+	// like Error(), empty messages fall back to the user message
+	err = New("", WithUserMessage("yikes"))
+	assert.Equal(t, err.Error(), Message(err))
+	assert.Equal(t, "yikes", Message(Wrap(err, WithHTTPCode(404))))
+	assert.Equal(t, "boom", Message(New("boom", WithUserMessage("yikes"))))
+
+	// foreign errors are returned as is
+	assert.Equal(t, "big: boom", Message(Wrap(fmt.Errorf("big: %w", New("boom")))))
+}
+
+func TestMessageHistory(t *testing.T) {
+	assert.Nil(t, MessageHistory(nil))
+	assert.Equal(t, []string{"boom"}, MessageHistory(New("boom")))
+
+	err := Prepend(Append(New("boom"), "db"), "load user")
+	assert.Equal(t, []string{"load user: boom: db", "boom: db", "boom"}, MessageHistory(err))
+
+	// other layers are skipped, and repeated messages are only included once
+	err = Wrap(err, WithHTTPCode(404), WithMessage("load user: boom: db"), WithMessage("not found"))
+	assert.Equal(t, []string{"not found", "load user: boom: db", "boom: db", "boom"}, MessageHistory(err))
+
+	// foreign errors end the history
+	err = Prepend(fmt.Errorf("wrapped: %w", Prepend(New("boom"), "big")), "outer")
+	assert.Equal(t, []string{"outer: wrapped: big: boom", "wrapped: big: boom"}, MessageHistory(err))
+}
+
+func TestWrapDeadline(t *testing.T) {
+	assert.Nil(t, WrapDeadline(context.Background(), nil))
+
+	// contexts without deadlines are ignored
+	err := WrapDeadline(context.Background(), errors.New("boom"))
+	assert.EqualError(t, err, "boom")
+	_, ok := Deadline(err)
+	assert.False(t, ok)
+	assert.Nil(t, Cause(err))
+
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// live contexts record the deadline, but no cause
+	_, _, rl, _ := runtime.Caller(0)
+	err = WrapDeadline(ctx, errors.New("boom"))
+	d, ok := Deadline(err)
+	assert.True(t, ok)
+	assert.True(t, deadline.Equal(d))
+	assert.Contains(t, Details(err), "Deadline: ")
+	assert.Nil(t, Cause(err))
+	_, l := Location(err)
+	assert.Equal(t, rl+1, l)
+
+	// exceeded deadlines are attached as the cause
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err = WrapDeadline(ctx, errors.New("boom"))
+	_, ok = Deadline(err)
+	assert.True(t, ok)
+	assert.Equal(t, context.DeadlineExceeded, Cause(err))
+
+	// errors which already match are left alone
+	err = WrapDeadline(ctx, fmt.Errorf("boom: %w", context.DeadlineExceeded))
+	assert.Nil(t, Cause(err))
+
+	// an existing cause is kept
+	err = WrapDeadline(ctx, New("boom", WithCause(io.EOF)))
+	assert.Equal(t, io.EOF, Cause(err))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWrapRecover(t *testing.T) {
+	assert.Nil(t, WrapRecover(nil))
+
+	var rl int
+	recovered := func(v interface{}, wrappers ...Wrapper) (err error) {
+		defer func() {
+			err = WrapRecover(recover(), wrappers...)
+		}()
+		_, _, rl, _ = runtime.Caller(0)
+		panic(v)
+	}
+
+	err := recovered("bang", WithHTTPCode(503))
+	assert.EqualError(t, err, "panic: bang")
+	assert.Equal(t, 503, HTTPCode(err))
+
+	// the stack starts where the panic occurred
+	_, l := Location(err)
+	assert.Equal(t, rl+1, l)
+
+	// recovered errors are wrapped
+	cause := errors.New("boom")
+	err = recovered(cause)
+	assert.EqualError(t, err, "panic: boom")
+	assert.ErrorIs(t, err, cause)
+
+	// existing stacks are kept
+	cause = New("boom")
+	err = recovered(cause)
+	assert.Equal(t, Stack(cause), Stack(err))
+}
+
+func TestValueMatcher(t *testing.T) {
+	err := New("boom", WithValue("kind", "notfound"), WithValue("ids", []int{1, 2}))
+
+	assert.ErrorIs(t, err, ValueMatcher("kind", "notfound"))
+	assert.NotErrorIs(t, err, ValueMatcher("kind", "conflict"))
+	assert.NotErrorIs(t, err, ValueMatcher("color", "notfound"))
+	assert.NotErrorIs(t, errors.New("boom"), ValueMatcher("kind", "notfound"))
+
+	// uncomparable values
+	assert.ErrorIs(t, err, ValueMatcher("ids", []int{1, 2}))
+
+	// nil values
+	assert.NotErrorIs(t, err, ValueMatcher("kind", nil))
+	nilErr := New("boom", WithValue("kind", nil))
+	assert.ErrorIs(t, nilErr, ValueMatcher("kind", nil))
+	assert.NotErrorIs(t, nilErr, ValueMatcher("kind", "notfound"))
+
+	// searches wrappers and causes
+	assert.ErrorIs(t, fmt.Errorf("wrapped: %w", err), ValueMatcher("kind", "notfound"))
+	assert.ErrorIs(t, New("bang", WithCause(err)), ValueMatcher("kind", "notfound"))
+
+	// removed values don't match, but the rest of the error does
+	sentinel := errors.New("io")
+	err = Wrap(err, WithCause(sentinel), WithValue("color", "red"))
+	masked := Wrap(err, WithoutValue("kind"), WithHTTPCode(404))
+	assert.NotErrorIs(t, masked, ValueMatcher("kind", "notfound"))
+	assert.NotErrorIs(t, fmt.Errorf("wrapped: %w", masked), ValueMatcher("kind", "notfound"))
+	assert.ErrorIs(t, masked, ValueMatcher("ids", []int{1, 2}))
+	assert.ErrorIs(t, masked, ValueMatcher("color", "red"))
+	assert.ErrorIs(t, masked, err)
+	assert.ErrorIs(t, masked, sentinel)
+	var target *errWithCause
+	assert.ErrorAs(t, masked, &target)
+
+	// values of the cause aren't masked
+	assert.ErrorIs(t, Wrap(New("bang", WithCause(err)), WithoutValue("kind")), ValueMatcher("kind", "notfound"))
+}
+
+func TestValueAs(t *testing.T) {
+	_, ok := ValueAs[int](nil, "id")
+	assert.False(t, ok)
+
+	err := New("boom", WithValue("id", 5))
+
+	id, ok := ValueAs[int](err, "id")
+	assert.True(t, ok)
+	assert.Equal(t, 5, id)
+
+	// missing value
+	_, ok = ValueAs[int](err, "color")
+	assert.False(t, ok)
+
+	// type mismatch
+	s, ok := ValueAs[string](err, "id")
+	assert.False(t, ok)
+	assert.Empty(t, s)
+}
+
+func TestAsGeneric(t *testing.T) {
+	_, ok := As[*fs.PathError](nil)
+	assert.False(t, ok)
+
+	pathErr := &fs.PathError{Op: "open", Path: "/tmp/x", Err: fs.ErrNotExist}
+	err := Prepend(pathErr, "loading config")
+
+	pe, ok := As[*fs.PathError](err)
+	assert.True(t, ok)
+	assert.Same(t, pathErr, pe)
+
+	// finds errors in causes
+	pe, ok = As[*fs.PathError](New("boom", WithCause(err)))
+	assert.True(t, ok)
+	assert.Same(t, pathErr, pe)
+
+	// no match returns the zero value
+	ue, ok := As[*UnwrapperError](err)
+	assert.False(t, ok)
+	assert.Nil(t, ue)
+}
+
+func TestSameKind(t *testing.T) {
+	assert.True(t, SameKind(nil, nil))
+	assert.False(t, SameKind(New("boom"), nil))
+	assert.False(t, SameKind(nil, New("boom")))
+
+	newErr := func(id int) error {
+		return New("not found", WithMessagef("user %d not found", id), WithValue("id", id))
+	}
+
+	// same code path, different data and line numbers
+	a := newErr(1)
+	b := Wrap(newErr(2), WithHTTPCode(404))
+	assert.True(t, SameKind(a, b))
+
+	// different root message
+	assert.False(t, SameKind(a, New("not found", WithMessage("user 1 not found"))))
+
+	// same message, different function
+	assert.False(t, SameKind(New("not found"), a))
+
+	// errors without stacks just compare messages
+	assert.True(t, SameKind(errors.New("boom"), Wrap(errors.New("boom"), NoCaptureStack())))
+	assert.False(t, SameKind(errors.New("boom"), errors.New("bam")))
+}
+
+func BenchmarkNew_noCaptureStack(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = New("boom", NoCaptureStack())
+	}
+}
+
+func TestConcurrentSettings(t *testing.T) {
+	// run with -race: changing the global settings while errors are
+	// being created on other goroutines should not race
+	defer SetStackCaptureEnabled(true)
+	defer SetMaxStackDepth(MaxStackDepth())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = Details(New("boom"))
+			}
+		}()
+	}
+
+	for j := 0; j < 100; j++ {
+		SetStackCaptureEnabled(j%2 == 0)
+		SetMaxStackDepth(10 + j)
+	}
+
+	wg.Wait()
+}
+
 func TestHasStack(t *testing.T) {
 	// nil -> false
 	assert.False(t, HasStack(nil))
@@ -380,8 +1607,149 @@ func TestRegisteredDetails(t *testing.T) {
 	// nil -> nil
 	assert.Nil(t, RegisteredDetails(nil))
 
-	assert.Equal(t, dict{"User Message": nil, "HTTP Code": nil}, RegisteredDetails(New("boom")))
-	assert.Equal(t, dict{"User Message": "blue", "HTTP Code": 5}, RegisteredDetails(New("boom", WithUserMessage("blue"), WithHTTPCode(5))))
+	assert.Equal(t, dict{"User Message": nil, "HTTP Code": nil, "Locale": nil, "User Message Key": nil, "HTTP Headers": nil, "Layer": nil, "Kind": nil, "Operation": nil, "Elapsed": nil, "Hint": nil, "Previous Message": nil, "Caller": nil, "Deadline": nil, "Build": nil, "Severity": nil}, RegisteredDetails(New("boom")))
+	assert.Equal(t, dict{"User Message": "blue", "HTTP Code": 5, "Locale": "fr-FR", "User Message Key": nil, "HTTP Headers": nil, "Layer": nil, "Kind": nil, "Operation": nil, "Elapsed": nil, "Hint": nil, "Previous Message": nil, "Caller": nil, "Deadline": nil, "Build": nil, "Severity": nil}, RegisteredDetails(New("boom", WithUserMessage("blue"), WithHTTPCode(5), WithLocale("fr-FR"))))
 }
 
 type dict = map[string]interface{}
+
+func BenchmarkIs(b *testing.B) {
+	// a diamond-shaped chain: each error wraps the previous error, and
+	// also has the previous error as its cause.
+	target := errors.New("target")
+	err := New("boom")
+	for i := 0; i < 1000; i++ {
+		err = Wrap(err, WithCause(err), WithValue("i", i))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if errors.Is(err, target) {
+			b.Fatal("should not match")
+		}
+	}
+}
+
+func TestRegisterWrapperPackage(t *testing.T) {
+	defer func() {
+		wrapperPackagesLock.Lock()
+		wrapperPackages = nil
+		wrapperPackagesLock.Unlock()
+	}()
+
+	// a helper, like an application's own error package would have
+	newErr := func() error {
+		return New("boom")
+	}
+
+	topFunction := func(err error) string {
+		frame, _ := resolveFrame(Stack(err)[0])
+		return frame.Function
+	}
+
+	assert.Contains(t, topFunction(newErr()), "github.com/ansel1/merry/v2.TestRegisterWrapperPackage.func")
+
+	// the tests are in this package, so all their frames are dropped, once
+	// any wrapper packages are registered
+	RegisterWrapperPackage("example.com/errs")
+	err := newErr()
+	assert.Equal(t, "testing.tRunner", topFunction(err))
+	assert.Equal(t, "testing.tRunner", topFunction(Wrap(errors.New("boom"), AddStack())))
+	err = Wrap(errors.New("boom"), LocationOnly())
+	assert.Equal(t, "testing.tRunner", topFunction(err))
+	assert.Len(t, Stack(err), 1)
+	assert.Equal(t, "testing.tRunner", topFunction(Wrap(errors.New("boom"), CaptureStackHeadTail(1, 1))))
+
+	// stacks are never trimmed entirely
+	RegisterWrapperPackage("testing")
+	RegisterWrapperPackage("runtime")
+	assert.NotEmpty(t, Stack(newErr()))
+}
+
+func TestSnapshot(t *testing.T) {
+	snapshot := Snapshot()
+	defer snapshot.Restore()
+
+	sentinel := errors.New("sentinel")
+
+	SetStackCaptureEnabled(false)
+	SetMaxStackDepth(5)
+	SetMaxMessageLength(3)
+	SetMaxCauseDepth(2)
+	SetHTTPCodeDefaulting(false)
+	SetDebugChecks(true)
+	SetUserMessageLocalizer(func(key, locale string) string { return key })
+	SetFrameFormatter(func(frame Frame) string { return frame.Function })
+	AddHooks(WithValue("color", "red"))
+	RegisterDetail("Color", "color")
+	RegisterKindHTTPCode("snapshot", 418)
+	RegisterLayer("snapshot/layer", "snapshot")
+	RegisterHTTPCode(sentinel, 418)
+	RegisterPublicValue("color")
+	RegisterWrapperPackage("snapshot/wrapper")
+
+	snapshot.Restore()
+
+	assert.True(t, StackCaptureEnabled())
+	assert.Equal(t, 50, MaxStackDepth())
+	assert.Equal(t, 0, MaxMessageLength())
+	assert.Equal(t, 0, MaxCauseDepth())
+	assert.True(t, HTTPCodeDefaulting())
+	assert.False(t, DebugChecks())
+	assert.Nil(t, UserMessageLocalizer())
+	assert.Nil(t, FrameFormatter())
+	assert.Empty(t, hooks)
+	assert.NotContains(t, registeredDetailFields(), "Color")
+	assert.Equal(t, 0, kindHTTPCode("snapshot"))
+	assert.NotContains(t, layers, "snapshot/layer")
+	assert.Equal(t, 0, registeredHTTPCode(sentinel))
+	assert.NotContains(t, publicValueKeys(), "color")
+	assert.Empty(t, wrapperPackageList())
+
+	err := New("boom")
+	assert.Nil(t, Value(err, "color"))
+	assert.NotEmpty(t, Stack(err))
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func TestCloseWith(t *testing.T) {
+	closeErr := errors.New("close failed")
+	failing := closerFunc(func() error { return closeErr })
+	succeeding := closerFunc(func() error { return nil })
+
+	run := func(closer io.Closer, result error, msg string) (err error) {
+		defer CloseWith(closer, &err, msg)
+		return result
+	}
+
+	// no errors
+	assert.NoError(t, run(succeeding, nil, "closing"))
+
+	// the close error is returned, with context
+	err := run(failing, nil, "closing file")
+	assert.ErrorIs(t, err, closeErr)
+	assert.EqualError(t, err, "closing file: close failed")
+	assert.NotEmpty(t, Stack(err))
+
+	// without context
+	assert.EqualError(t, run(failing, nil, ""), "close failed")
+
+	// the original error is kept, and the close error is suppressed
+	writeErr := errors.New("write failed")
+	err = run(failing, writeErr, "closing file")
+	assert.ErrorIs(t, err, writeErr)
+	assert.NotErrorIs(t, err, closeErr)
+	assert.EqualError(t, err, "write failed")
+	suppressed := Suppressed(err)
+	require.Len(t, suppressed, 1)
+	assert.EqualError(t, suppressed[0], "closing file: close failed")
+
+	// a successful close leaves the original error alone
+	assert.Equal(t, writeErr, run(succeeding, writeErr, "closing file"))
+}
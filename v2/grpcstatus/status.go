@@ -22,7 +22,11 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Status references google.golang.org/grpc/status
@@ -107,11 +111,26 @@ func FromContextError(err error) *Status {
 }
 
 // WithCode is a merry.Wrapper which associates a GRPC code with the error.
-// Code() will return this value.
+// Code() will return this value.  The code is preserved by merry.Client().
 func WithCode(code codes.Code) merry.Wrapper {
 	return merry.WithValue(errValueKeyCode, code)
 }
 
+// WithDetail is a merry.Wrapper which attaches a status detail to the error, typically one
+// of the errdetails messages, like a QuotaFailure or PreconditionFailure.  DetailsFromError
+// includes the attached details, after the details it derives from the error.  Each call
+// adds another detail.
+func WithDetail(msg proto.Message) merry.Wrapper {
+	return merry.WrapperFunc(func(err error, _ int) error {
+		if err == nil || msg == nil {
+			return err
+		}
+		details, _ := merry.Value(err, errValueKeyDetails).([]proto.Message)
+		details = append(details[:len(details):len(details)], msg)
+		return merry.Set(err, errValueKeyDetails, details)
+	})
+}
+
 // Code returns the grpc response code for an error.  It is
 // similar to status.Code(), and should behave identically
 // to that function for non-merry errors.  If err is a merry
@@ -123,7 +142,12 @@ func WithCode(code codes.Code) merry.Wrapper {
 // - errors.As(GRPCStatuser): return code from Status
 // - errors.Is(context.DeadlineExceeded): codes.DeadlineExceeded
 // - errors.Is(context.Canceled: codes.Canceled
-// - default: CodeFromHTTPStatus(), which defaults to codes.Unknown
+// - http code previously set with merry.WithHTTPCode(): CodeFromHTTPStatus(code)
+// - merry.KindOf(err) has a code registered with RegisterKindGRPCCode()
+// - default: CodeFromHTTPStatus(merry.HTTPCode(err)), which defaults to codes.Unknown
+//
+// merry.HTTPCode() consults the code registered for the kind with merry.RegisterKindHTTPCode(),
+// so kinds only registered with an HTTP code are mapped to grpc codes too.
 func Code(err error) codes.Code {
 	if err == nil {
 		return codes.OK
@@ -142,9 +166,42 @@ func Code(err error) codes.Code {
 		return codes.DeadlineExceeded
 	case errors.Is(err, context.Canceled):
 		return codes.Canceled
-	default:
-		return CodeFromHTTPStatus(merry.HTTPCode(err))
 	}
+
+	if httpCode, ok := merry.AttachedHTTPCode(err); ok {
+		return CodeFromHTTPStatus(httpCode)
+	}
+
+	if code, ok := kindCode(merry.KindOf(err)); ok {
+		return code
+	}
+
+	return CodeFromHTTPStatus(merry.HTTPCode(err))
+}
+
+var kindsLock sync.Mutex
+var kindCodes = map[string]codes.Code{}
+
+// RegisterKindGRPCCode maps an error kind to a grpc code.  Code() returns this code for
+// errors of this kind (see merry.WithKind), unless a code takes precedence, like a code
+// attached with WithCode.  See Code().
+func RegisterKindGRPCCode(kind string, code codes.Code) {
+	kindsLock.Lock()
+	defer kindsLock.Unlock()
+
+	kindCodes[kind] = code
+}
+
+func kindCode(kind string) (codes.Code, bool) {
+	if kind == "" {
+		return codes.OK, false
+	}
+
+	kindsLock.Lock()
+	defer kindsLock.Unlock()
+
+	code, ok := kindCodes[kind]
+	return code, ok
 }
 
 func lookupCode(err error) (codes.Code, bool) {
@@ -156,34 +213,110 @@ func lookupCode(err error) (codes.Code, bool) {
 }
 
 // DefaultLocalizedMessageLocale is the value used when encoding a merry.UserMessage()
-// to a errdetails.LocalizedMessage.
+// to a errdetails.LocalizedMessage, if the error has no locale attached with
+// merry.WithLocale().
 var DefaultLocalizedMessageLocale = "en-US"
 
+// These settings are accessed atomically, so they can be changed safely while errors
+// are being converted on other goroutines.
+var excludeDebugInfo int32
+var debugInfoValueKeys atomic.Value // []interface{}, copied on write
+
+// IncludeDebugInfo returns whether DetailsFromError derives a DebugInfo from the error.
+// Defaults to true.
+func IncludeDebugInfo() bool {
+	return atomic.LoadInt32(&excludeDebugInfo) == 0
+}
+
+// SetIncludeDebugInfo controls whether DetailsFromError derives a DebugInfo from the error.
+// DebugInfo contains internal information, like stacks, which should only be sent to
+// internal clients.  Services which serve external clients should set this to false.
+func SetIncludeDebugInfo(include bool) {
+	var exclude int32
+	if !include {
+		exclude = 1
+	}
+	atomic.StoreInt32(&excludeDebugInfo, exclude)
+}
+
+// DebugInfoValueKeys returns the keys set with SetDebugInfoValueKeys.
+func DebugInfoValueKeys() []interface{} {
+	return append([]interface{}(nil), loadDebugInfoValueKeys()...)
+}
+
+func loadDebugInfoValueKeys() []interface{} {
+	keys, _ := debugInfoValueKeys.Load().([]interface{})
+	return keys
+}
+
+// SetDebugInfoValueKeys selects merry values to include in the DebugInfo derived by
+// DetailsFromError.  The values attached to the error with these keys are written to
+// DebugInfo.Detail, one "key=value" line per key, in order.  Keys which have no value
+// attached to the error are skipped.  Like the stack, these values are only included
+// if IncludeDebugInfo() is true.  Replaces the keys set by earlier calls.
+func SetDebugInfoValueKeys(keys ...interface{}) {
+	debugInfoValueKeys.Store(append([]interface{}(nil), keys...))
+}
+
 // DetailsFromError derives status details from context attached to the error:
 //
-// - if the err has a user message, it will be converted into a LocalizedMessage.
-// - if the err has a stack, it will be converted into a DebugInfo.
+//   - if the err has a user message, it will be converted into a LocalizedMessage.  The
+//     message's locale is merry.Locale(err), or DefaultLocalizedMessageLocale if not set.
+//   - if the err has a retry delay attached with merry.WithRetryAfter(), it will be converted
+//     into a RetryInfo.
+//   - if IncludeDebugInfo() is true, and the err has a stack, or values selected by
+//     SetDebugInfoValueKeys, they will be converted into a DebugInfo.
+//   - the details attached to the err with WithDetail() are included as-is, in the
+//     order they were attached.
 //
 // Returns nil if no details are derived from the error.
 func DetailsFromError(err error) []proto.Message {
 	var details []proto.Message
 
 	if um := merry.UserMessage(err); um != "" {
+		locale := merry.Locale(err)
+		if locale == "" {
+			locale = DefaultLocalizedMessageLocale
+		}
 		details = append(details, &errdetails.LocalizedMessage{
 			Message: um,
-			Locale:  DefaultLocalizedMessageLocale,
+			Locale:  locale,
 		})
 	}
 
-	if formattedStack := merry.FormattedStack(err); len(formattedStack) > 0 {
-		details = append(details, &errdetails.DebugInfo{
-			StackEntries: formattedStack,
+	if d, ok := merry.RetryAfter(err); ok {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(d),
 		})
 	}
 
+	if IncludeDebugInfo() {
+		formattedStack := merry.FormattedStack(err)
+		detail := debugInfoDetail(err)
+		if len(formattedStack) > 0 || detail != "" {
+			details = append(details, &errdetails.DebugInfo{
+				StackEntries: formattedStack,
+				Detail:       detail,
+			})
+		}
+	}
+
+	attached, _ := merry.Value(err, errValueKeyDetails).([]proto.Message)
+	details = append(details, attached...)
+
 	return details
 }
 
+func debugInfoDetail(err error) string {
+	var lines []string
+	for _, key := range loadDebugInfoValueKeys() {
+		if v, ok := merry.Lookup(err, key); ok {
+			lines = append(lines, fmt.Sprintf("%v=%v", key, v))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // CodeFromHTTPStatus returns a grpc code from an http status code.  It returns
 // the inverse of github.com/grpc-ecosystem/grpc-gateway/v2/runtime.HTTPStatusFromCode,
 // plus some additional HTTP code mappings.
@@ -256,3 +389,11 @@ type errValueKey int
 
 // errValueKeyCode is a private key for storing a grpc code as a merry error value
 const errValueKeyCode = iota
+
+// errValueKeyDetails is a private key for storing the details attached with WithDetail
+const errValueKeyDetails errValueKey = 1
+
+func init() {
+	// codes are meant for clients, so merry.Client() preserves them
+	merry.RegisterPublicValue(errValueKeyCode)
+}
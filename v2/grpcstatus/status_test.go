@@ -11,9 +11,11 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"net/http"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -164,6 +166,11 @@ func TestWithCode(t *testing.T) {
 	mapstest.AssertContains(t, Convert(err).Details(), &errdetails.LocalizedMessage{Message: "yikes"})
 }
 
+func TestClientCode(t *testing.T) {
+	err := merry.New("boom", WithCode(codes.PermissionDenied))
+	assert.Equal(t, codes.PermissionDenied, Code(merry.Client(err)))
+}
+
 func TestCode(t *testing.T) {
 	// nil -> ok
 	assert.Equal(t, codes.OK, Code(nil))
@@ -180,6 +187,23 @@ func TestCode(t *testing.T) {
 	// mapped from http code
 	assert.Equal(t, codes.Unauthenticated, Code(merry.New("blue", merry.WithHTTPCode(http.StatusUnauthorized))))
 
+	// mapped from kind
+	RegisterKindGRPCCode("Conflict", codes.Aborted)
+	defer delete(kindCodes, "Conflict")
+	assert.Equal(t, codes.Aborted, Code(merry.New("blue", merry.WithKind("Conflict"))))
+
+	// explicit codes win
+	assert.Equal(t, codes.AlreadyExists, Code(merry.New("blue", merry.WithKind("Conflict"), WithCode(codes.AlreadyExists))))
+	assert.Equal(t, codes.NotFound, Code(merry.New("blue", merry.WithKind("Conflict"), merry.WithHTTPCode(http.StatusNotFound))))
+
+	// unregistered kinds fall back to the http code
+	assert.Equal(t, codes.NotFound, Code(merry.New("blue", merry.WithKind("Missing"), merry.WithHTTPCode(http.StatusNotFound))))
+
+	// kinds with only an http code registered are mapped through it
+	merry.RegisterKindHTTPCode("Missing", http.StatusNotFound)
+	defer merry.RegisterKindHTTPCode("Missing", 0)
+	assert.Equal(t, codes.NotFound, Code(merry.New("blue", merry.WithKind("Missing"))))
+
 	// default
 	assert.Equal(t, codes.Unknown, Code(errors.New("blue")))
 }
@@ -197,6 +221,42 @@ func TestDetailsFromError(t *testing.T) {
 		&errdetails.LocalizedMessage{Message: "yikes", Locale: "en-US"},
 		&errdetails.DebugInfo{StackEntries: []string{"blue", "red"}},
 	}, DetailsFromError(err))
+
+	// the error's locale is used, if set
+	err = merry.Wrap(err, merry.WithLocale("fr-FR"))
+	assert.Equal(t, &errdetails.LocalizedMessage{Message: "yikes", Locale: "fr-FR"}, DetailsFromError(err)[0])
+
+	// retry delays are converted into a RetryInfo
+	err = merry.New("blue", merry.WithRetryAfter(5*time.Second), merry.NoCaptureStack())
+	assert.Equal(t, []proto.Message{
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(5 * time.Second)},
+	}, DetailsFromError(err))
+
+	// selected values are included in the DebugInfo
+	SetDebugInfoValueKeys("color", "missing", "size")
+	defer SetDebugInfoValueKeys()
+	assert.Equal(t, []interface{}{"color", "missing", "size"}, DebugInfoValueKeys())
+	err = merry.New("blue", merry.WithValue("color", "red"), merry.WithValue("size", 5), merry.WithFormattedStack([]string{"blue"}))
+	assert.Equal(t, []proto.Message{
+		&errdetails.DebugInfo{StackEntries: []string{"blue"}, Detail: "color=red\nsize=5"},
+	}, DetailsFromError(err))
+
+	// attached details are included after the derived details
+	quota := &errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{Subject: "user:1"}}}
+	precondition := &errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{{Type: "TOS"}}}
+	withDetails := merry.New("blue", merry.WithUserMessage("yikes"), WithDetail(quota), merry.NoCaptureStack())
+	withDetails = merry.Wrap(withDetails, WithDetail(precondition))
+	assert.Equal(t, []proto.Message{
+		&errdetails.LocalizedMessage{Message: "yikes", Locale: "en-US"},
+		quota,
+		precondition,
+	}, DetailsFromError(withDetails))
+
+	// DebugInfo can be disabled
+	SetIncludeDebugInfo(false)
+	defer SetIncludeDebugInfo(true)
+	assert.False(t, IncludeDebugInfo())
+	assert.Nil(t, DetailsFromError(err))
 }
 
 func TestCodeFromHTTPStatus(t *testing.T) {
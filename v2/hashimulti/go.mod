@@ -0,0 +1,18 @@
+module github.com/ansel1/merry/v2/hashimulti
+
+go 1.18
+
+require (
+	github.com/ansel1/merry/v2 v2.0.0-00010101000000-000000000000
+	github.com/hashicorp/go-multierror v1.1.1
+	github.com/stretchr/testify v1.8.3
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/ansel1/merry/v2 => ../
@@ -0,0 +1,40 @@
+// Package hashimulti provides a merry hook to integrate github.com/hashicorp/go-multierror
+// errors with merry.  The hook will detect multierrors, and attach each of the errors
+// they contain as suppressed errors of the merry error, so they are printed by
+// merry.Details().
+//
+// hashimulti is a separate module, so go-multierror only becomes a dependency of
+// programs which use it.
+package hashimulti
+
+import (
+	"errors"
+	"github.com/ansel1/merry/v2"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Install installs IntegrateErrors() as a merry hook.
+func Install() {
+	merry.AddHooks(IntegrateErrors())
+}
+
+// IntegrateErrors searches the error chain for a *multierror.Error.  The errors it
+// contains are attached to the merry error with merry.WithSuppressed, in order.  They
+// are siblings, e.g. errors from operations which ran in parallel, so they are attached
+// side by side, rather than as a chain of causes.  errors.Is() and errors.As() already
+// traverse multierrors, so this mainly makes the individual errors visible in
+// merry.Details().
+//
+// Errors which already have suppressed errors are left alone.  Stacks attached to the
+// contained errors are found by merry already, so no special handling is needed for them.
+func IntegrateErrors() merry.Wrapper {
+	return merry.WrapperFunc(func(err error, depth int) error {
+		var me *multierror.Error
+
+		if err != nil && len(merry.Suppressed(err)) == 0 && errors.As(err, &me) {
+			return merry.WithSuppressed(me.WrappedErrors()...).Wrap(err, depth)
+		}
+
+		return err
+	})
+}
@@ -0,0 +1,41 @@
+package hashimulti
+
+import (
+	"errors"
+	"github.com/ansel1/merry/v2"
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestHook(t *testing.T) {
+	merry.ClearHooks()
+	defer merry.ClearHooks()
+	Install()
+
+	e1 := errors.New("crash")
+	e2 := merry.New("bang")
+	err := merry.Wrap(multierror.Append(e1, e2), merry.WithMessage("yikes"))
+
+	assert.EqualError(t, err, "yikes")
+
+	// the errors are siblings, not causes of each other
+	assert.Equal(t, []error{e1, e2}, merry.Suppressed(err))
+	assert.Nil(t, merry.Cause(err))
+	details := merry.Details(err)
+	assert.Contains(t, details, "Suppressed:\n\tcrash")
+	assert.Contains(t, details, "\n\tbang")
+	assert.NotContains(t, details, "Caused By")
+
+	assert.ErrorIs(t, err, e1)
+	assert.ErrorIs(t, err, e2)
+
+	// wrapping again does not import the errors again
+	err = merry.Wrap(err)
+	assert.Len(t, merry.Suppressed(err), 2)
+	assert.Equal(t, 1, strings.Count(merry.Details(err), "\tcrash"))
+
+	// other errors are unaffected
+	assert.Nil(t, merry.Suppressed(merry.New("boom")))
+}
@@ -1,5 +1,7 @@
 package merry
 
+import "reflect"
+
 var hooks []Wrapper
 var onceHooks []Wrapper
 
@@ -11,12 +13,38 @@ var onceHooks []Wrapper
 // Note that these hooks will be applied each time an err is passed to Wrap/Apply.  If you
 // only want your hook to run once per error, see AddOnceHooks.
 //
+// Hooks are applied in the order they were added.  Once hooks are applied before the
+// other hooks.
+//
 // This function is not thread safe, and should only be called very early in program
 // initialization.
 func AddHooks(hook ...Wrapper) {
 	hooks = append(hooks, hook...)
 }
 
+// AddHook is like AddHooks, but installs a single hook, and returns a function which
+// removes exactly that hook.  Unlike RemoveHook, this works for any Wrapper, including
+// WrapperFuncs.  Calling the returned function more than once is harmless.  Tests and
+// plugins can defer it to clean up:
+//
+//	defer merry.AddHook(myHook)()
+//
+// This function is not thread safe, and should only be called very early in program
+// initialization, or in tests.
+func AddHook(hook Wrapper) (remove func()) {
+	h := &registeredHook{Wrapper: hook}
+	hooks = append(hooks, h)
+	return func() {
+		hooks, _ = removeWrapper(hooks, h)
+	}
+}
+
+// registeredHook gives a hook installed by AddHook an identity, so it can be removed
+// even if the hook itself isn't comparable.
+type registeredHook struct {
+	Wrapper
+}
+
 // AddOnceHooks is like AddHooks, but these hooks will only be applied once per error.
 // Once hooks are applied to an error, the error is marked, and future Wrap/Apply calls
 // on the error will not apply these hooks again.
@@ -27,6 +55,37 @@ func AddOnceHooks(hook ...Wrapper) {
 	onceHooks = append(onceHooks, hook...)
 }
 
+// RemoveHook removes a hook installed with AddHooks or AddOnceHooks, leaving the order of
+// the other hooks unchanged.  Hooks are identified by equality, so only hooks of comparable
+// types can be removed, e.g. pointers to types which implement Wrapper.  WrapperFuncs aren't
+// comparable, so they can't be removed this way.  If the hook was installed several times,
+// only the first is removed.  Returns false if the hook wasn't found.
+//
+// This function is not thread safe, and should only be called very early in program
+// initialization, or in tests.
+func RemoveHook(hook Wrapper) bool {
+	var removed bool
+	if hooks, removed = removeWrapper(hooks, hook); removed {
+		return true
+	}
+	onceHooks, removed = removeWrapper(onceHooks, hook)
+	return removed
+}
+
+// removeWrapper returns a copy of wrappers without the first wrapper equal to w.
+func removeWrapper(wrappers []Wrapper, w Wrapper) ([]Wrapper, bool) {
+	if w == nil || !reflect.TypeOf(w).Comparable() {
+		return wrappers, false
+	}
+	for i, h := range wrappers {
+		// h can only equal w if it has the same, comparable type
+		if h == w {
+			return append(wrappers[:i:i], wrappers[i+1:]...), true
+		}
+	}
+	return wrappers, false
+}
+
 // ClearHooks removes all installed hooks.
 //
 // This function is not thread safe, and should only be called very early in program
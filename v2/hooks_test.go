@@ -38,3 +38,79 @@ func TestHooks(t *testing.T) {
 	Wrap(err)
 	assert.Equal(t, 2, appliedCount)
 }
+
+type countingHook struct {
+	name    string
+	applied *[]string
+}
+
+func (h *countingHook) Wrap(err error, _ int) error {
+	*h.applied = append(*h.applied, h.name)
+	return err
+}
+
+func TestRemoveHook(t *testing.T) {
+	ClearHooks()
+	defer ClearHooks()
+
+	var applied []string
+	h1 := &countingHook{name: "h1", applied: &applied}
+	h2 := &countingHook{name: "h2", applied: &applied}
+	h3 := &countingHook{name: "h3", applied: &applied}
+	funcHook := WrapperFunc(func(err error, _ int) error {
+		applied = append(applied, "func")
+		return err
+	})
+	AddHooks(h1, funcHook, h2)
+	AddOnceHooks(h3)
+
+	// once hooks first, then hooks in the order they were added
+	Wrap(errors.New("boom"))
+	assert.Equal(t, []string{"h3", "h1", "func", "h2"}, applied)
+
+	assert.True(t, RemoveHook(h1))
+	assert.False(t, RemoveHook(h1))
+	assert.True(t, RemoveHook(h3))
+	assert.False(t, RemoveHook(&countingHook{name: "h2", applied: &applied}))
+	assert.False(t, RemoveHook(nil))
+
+	// functions can't be removed, but don't panic
+	assert.False(t, RemoveHook(funcHook))
+
+	applied = nil
+	Wrap(errors.New("boom"))
+	assert.Equal(t, []string{"func", "h2"}, applied)
+}
+
+func TestAddHook(t *testing.T) {
+	ClearHooks()
+	defer ClearHooks()
+
+	var applied []string
+	hook := func(name string) Wrapper {
+		return WrapperFunc(func(err error, _ int) error {
+			applied = append(applied, name)
+			return err
+		})
+	}
+
+	remove1 := AddHook(hook("h1"))
+	remove2 := AddHook(hook("h2"))
+	AddHooks(hook("h3"))
+
+	Wrap(errors.New("boom"))
+	assert.Equal(t, []string{"h1", "h2", "h3"}, applied)
+
+	remove1()
+	remove1()
+
+	applied = nil
+	Wrap(errors.New("boom"))
+	assert.Equal(t, []string{"h2", "h3"}, applied)
+
+	remove2()
+
+	applied = nil
+	Wrap(errors.New("boom"))
+	assert.Equal(t, []string{"h3"}, applied)
+}
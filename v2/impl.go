@@ -3,7 +3,10 @@ package merry
 import (
 	"errors"
 	"fmt"
+	"path"
 	"reflect"
+	"strings"
+	"unicode/utf8"
 )
 
 type errKey int
@@ -16,6 +19,29 @@ const (
 	errKeyUserMessage
 	errKeyForceCapture
 	errKeyHooked
+	errKeyBreadcrumb
+	errKeyLocale
+	errKeyUserMessageKey
+	errKeyBoundaryStack
+	errKeyHTTPHeaders
+	errKeyRetryAfter
+	errKeyLayer
+	errKeyKind
+	errKeyOp
+	errKeyVerbose
+	errKeySuppressed
+	errKeyHideStack
+	errKeyElapsed
+	errKeyHint
+	errKeyPreviousMessage
+	errKeyCaller
+	errKeyDeadline
+	errKeyLogged
+	errKeyBuild
+	errKeySeverity
+	errKeyMaxDepth
+	errKeyClass
+	errKeySourceInMessage
 )
 
 func (e errKey) String() string {
@@ -32,16 +58,141 @@ func (e errKey) String() string {
 		return "user message"
 	case errKeyForceCapture:
 		return "force stack capture"
+	case errKeyHooked:
+		return "hooked"
+	case errKeyBreadcrumb:
+		return "breadcrumb"
+	case errKeyLocale:
+		return "locale"
+	case errKeyUserMessageKey:
+		return "user message key"
+	case errKeyBoundaryStack:
+		return "boundary stack"
+	case errKeyHTTPHeaders:
+		return "http headers"
+	case errKeyRetryAfter:
+		return "retry after"
+	case errKeyLayer:
+		return "layer"
+	case errKeyKind:
+		return "kind"
+	case errKeyOp:
+		return "operation"
+	case errKeyVerbose:
+		return "verbose"
+	case errKeySuppressed:
+		return "suppressed"
+	case errKeyHideStack:
+		return "hide stack"
+	case errKeyElapsed:
+		return "elapsed"
+	case errKeyHint:
+		return "hint"
+	case errKeyPreviousMessage:
+		return "previous message"
+	case errKeyCaller:
+		return "caller"
+	case errKeyDeadline:
+		return "deadline"
+	case errKeyLogged:
+		return "logged"
+	case errKeyBuild:
+		return "build"
+	case errKeySeverity:
+		return "severity"
+	case errKeyMaxDepth:
+		return "max stack depth"
+	case errKeyClass:
+		return "class"
+	case errKeySourceInMessage:
+		return "source in message"
 	default:
 		return ""
 	}
 }
 
+// callerPC is the program counter of the frame captured by WithCaller.  It is resolved
+// lazily, when it is printed.
+type callerPC uintptr
+
+// String formats the frame like SourceLine.
+func (pc callerPC) String() string {
+	frame, ok := resolveFrame(uintptr(pc))
+	if !ok {
+		return ""
+	}
+	return sourceLine(frame)
+}
+
+// namedStackKey is the key for stacks attached with WithNamedStack.
+type namedStackKey struct {
+	label string
+}
+
+func (k namedStackKey) String() string {
+	return "stack: " + k.label
+}
+
+// groupKey namespaces a value key within a group.  See WithGroup.
+type groupKey struct {
+	group string
+	key   interface{}
+}
+
+func (k groupKey) String() string {
+	return fmt.Sprintf("%s.%v", k.group, k.key)
+}
+
+// truncateMessage shortens msg to MaxMessageLength() runes, if necessary.
+func truncateMessage(msg string) string {
+	max := MaxMessageLength()
+	// the byte length is an upper bound on the rune count, so most
+	// messages can skip counting runes
+	if max <= 0 || len(msg) <= max || utf8.RuneCountInString(msg) <= max {
+		return msg
+	}
+
+	// keep max-1 runes, leaving room for the ellipsis
+	i, n := 0, 0
+	for n < max-1 {
+		_, size := utf8.DecodeRuneInString(msg[i:])
+		i += size
+		n++
+	}
+	return msg[:i] + "…"
+}
+
+// joinError is the error returned by ToJoin.  It is equivalent to the errors
+// returned by errors.Join, which isn't available in all supported go versions.
+type joinError struct {
+	errs []error
+}
+
+// Error implements golang's error interface.  It joins the messages of the
+// errors with newlines, like errors.Join.
+func (e *joinError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the joined errors.
+func (e *joinError) Unwrap() []error {
+	return e.errs
+}
+
 // formatError adds a Format implementation to an error.
 type formatError struct {
 	error
 }
 
+// Error implements golang's error interface
+func (e *formatError) Error() string {
+	return truncateMessage(e.error.Error())
+}
+
 // Format implements fmt.Formatter
 func (e *formatError) Format(s fmt.State, verb rune) {
 	Format(s, verb, e)
@@ -69,14 +220,31 @@ func (e *errWithValue) Format(s fmt.State, verb rune) {
 
 // Error implements golang's error interface
 // returns the message value if set, otherwise
-// delegates to inner error
+// delegates to inner error.  If the inner error's
+// message is empty, returns the user message, if set.
 func (e *errWithValue) Error() string {
-	if e.key == errKeyMessage {
+	switch e.key {
+	case errKeyMessage:
 		if s, ok := e.value.(string); ok {
-			return s
+			return truncateMessage(s)
+		}
+	case errKeyUserMessage:
+		if msg := e.err.Error(); msg != "" {
+			return truncateMessage(msg)
+		}
+		if s, ok := e.value.(string); ok {
+			return truncateMessage(s)
+		}
+	case errKeyVerbose:
+		return Details(e.err)
+	case errKeySourceInMessage:
+		if pc, ok := e.value.(callerPC); ok {
+			if frame, ok := resolveFrame(uintptr(pc)); ok {
+				return truncateMessage(fmt.Sprintf("%s (%s:%d)", e.err.Error(), path.Base(frame.File), frame.Line))
+			}
 		}
 	}
-	return e.err.Error()
+	return truncateMessage(e.err.Error())
 }
 
 // String implements fmt.Stringer
@@ -84,17 +252,141 @@ func (e *errWithValue) String() string {
 	return e.Error()
 }
 
-// Unwrap returns the next wrapped error.
+// Unwrap returns the next wrapped error.  If the value was removed with WithoutValue, the
+// next error is wrapped in a shim, so ValueMatchers don't match the masked value.
 func (e *errWithValue) Unwrap() error {
+	if _, ok := e.value.(removedValue); ok {
+		return &maskedError{err: e.err, key: e.key}
+	}
 	return e.err
 }
 
+// Is supports matching errors against a ValueMatcher, and against the sentinels
+// attached with Classify, with errors.Is.
+func (e *errWithValue) Is(target error) bool {
+	if m, ok := target.(*valueMatcher); ok {
+		return m.Is(e)
+	}
+	if e.key == errKeyClass {
+		if class, ok := e.value.(error); ok {
+			return errors.Is(class, target)
+		}
+	}
+	return false
+}
+
 // isMerryError is a marker method for identifying error types implemented by this package.
 func (e *errWithValue) isMerryError() {}
 
+// autoHTTPCode is the http code attached by AutoHTTPCode.  It stands for the code
+// registered with RegisterHTTPCode for the first error the error matches, which is
+// looked up when the code is read.  See attachedHTTPCode.
+type autoHTTPCode struct{}
+
+func (autoHTTPCode) String() string {
+	return "<auto>"
+}
+
+// removedValue is the value attached by WithoutValue.  It masks any value
+// attached with the same key by the layers it wraps.
+type removedValue struct{}
+
+func (removedValue) String() string {
+	return "<removed>"
+}
+
+// maskedError is the shim returned by unwrapping a layer attached by WithoutValue.  It
+// stands in for each of the following layers of the same error, which errors.Is() and
+// errors.As() match as usual, except that ValueMatchers for the removed key don't match.
+// The error's cause isn't masked.
+type maskedError struct {
+	err error
+	key interface{}
+}
+
+func (e *maskedError) Error() string {
+	return e.err.Error()
+}
+
+// Is matches the masked layer, like errWithCause.Is.
+func (e *maskedError) Is(target error) bool {
+	if m, ok := target.(*valueMatcher); ok && m.key == e.key {
+		return false
+	}
+	if sameError(e.err, target) {
+		return true
+	}
+	x, ok := e.err.(interface{ Is(error) bool })
+	return ok && x.Is(target)
+}
+
+// As matches the masked layer, like errWithCause.As.
+func (e *maskedError) As(target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if reflect.TypeOf(e.err).AssignableTo(val.Type().Elem()) {
+		val.Elem().Set(reflect.ValueOf(e.err))
+		return true
+	}
+	x, ok := e.err.(interface{ As(interface{}) bool })
+	return ok && x.As(target)
+}
+
+// Unwrap masks the next layer of the error, or each of its branches.  Once the layers of
+// the error are exhausted, it returns the error's cause unmasked.
+func (e *maskedError) Unwrap() error {
+	if x, ok := e.err.(interface{ Unwrap() []error }); ok {
+		branches := x.Unwrap()
+		masked := make([]error, len(branches))
+		for i, branch := range branches {
+			masked[i] = &maskedError{err: branch, key: e.key}
+		}
+		return &joinError{errs: masked}
+	}
+	next := errors.Unwrap(e.err)
+	if next == nil {
+		return nil
+	}
+	if _, ok := e.err.(*errWithCause); ok {
+		// errWithCause unwraps to the shims which carry its cause along the rest of the
+		// chain, and finally to the cause itself, or the chain's branches and the cause.
+		if shim, ok := next.(*errWithCause); !ok || shim.chain == nil {
+			return next
+		}
+	}
+	return &maskedError{err: next, key: e.key}
+}
+
+// valueMatcher is the target returned by ValueMatcher.
+type valueMatcher struct {
+	key, value interface{}
+}
+
+func (m *valueMatcher) Error() string {
+	return fmt.Sprintf("value matcher: %v=%v", m.key, m.value)
+}
+
+// Is returns true if err has the matcher's value attached with the matcher's key.
+func (m *valueMatcher) Is(err error) bool {
+	v, ok := Lookup(err, m.key)
+	if !ok {
+		return false
+	}
+	if v == nil || m.value == nil {
+		return v == nil && m.value == nil
+	}
+	if reflect.TypeOf(v).Comparable() && reflect.TypeOf(m.value).Comparable() {
+		return v == m.value
+	}
+	return reflect.DeepEqual(v, m.value)
+}
+
 type errWithCause struct {
 	err   error
 	cause error
+
+	// chain is set on the shims returned by Unwrap.  It is the error
+	// at the top of the chain being unwrapped.
+	chain error
 }
 
 func (e *errWithCause) Unwrap() error {
@@ -111,13 +403,30 @@ func (e *errWithCause) Unwrap() error {
 		}
 	}
 
+	chain := e.chain
+	if chain == nil {
+		chain = e
+	}
+
+	// errors.Unwrap() doesn't follow errors which wrap several errors, like
+	// fmt.Errorf() with several %w verbs, so return their branches, followed
+	// by the cause.
+	if x, ok := nextErr.(interface{ Unwrap() []error }); ok {
+		branches := x.Unwrap()
+		errs := append(branches[:len(branches):len(branches)], unvisitedCause(chain, e.cause))
+		if errs[len(errs)-1] == nil {
+			errs = errs[:len(errs)-1]
+		}
+		return &joinError{errs: errs}
+	}
+
 	// errWithCause.Is/As() also already checked nextErr, so we want to
 	// unwrap it and get to the next error down.
 	nextErr = errors.Unwrap(nextErr)
 
 	// we've reached the end of this wrapper chain.  Return the cause.
 	if nextErr == nil {
-		return e.cause
+		return unvisitedCause(chain, e.cause)
 	}
 
 	// return a new errWithCause wrapper, wrapping next error, but bundling
@@ -125,7 +434,145 @@ func (e *errWithCause) Unwrap() error {
 	// over above.  This is how we carry the latest cause along as we unwrap
 	// the chain.  When we get to the end of the chain, we'll return this latest
 	// cause.
-	return &errWithCause{err: nextErr, cause: e.cause}
+	return &errWithCause{err: nextErr, cause: e.cause, chain: chain}
+}
+
+// unvisitedCause returns cause, unless cause is itself one of the layers of chain.  In that
+// case, errors.Is() and errors.As() have already visited cause and the rest of its chain, while
+// unwrapping chain, so visiting it again is wasted effort.  Instead, skip ahead to the
+// cause's own cause, which they haven't visited yet.
+//
+// Without this, errors which are both wrapped and attached as the cause, e.g.
+//
+//	err = merry.Wrap(err, merry.WithCause(err))
+//
+// are visited repeatedly, which becomes very expensive in deeply nested chains.
+func unvisitedCause(chain, cause error) error {
+	// a cause can't wrap the error it's the cause of, so the cause's cause can only
+	// be found further down the chain.  Resume searching from the last match.
+	layer := chain
+	for cause != nil {
+		for layer != nil && !sameError(layer, cause) {
+			layer = unwrapLayer(layer)
+		}
+		if layer == nil {
+			return cause
+		}
+		cause = Cause(cause)
+	}
+	return nil
+}
+
+// importedError is an error from another package, imported by ImportChain.  It doesn't
+// unwrap, because the error it wrapped is imported as its cause.
+type importedError struct {
+	err error
+}
+
+func (e *importedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *importedError) String() string {
+	return e.Error()
+}
+
+func (e *importedError) Format(f fmt.State, verb rune) {
+	Format(f, verb, e)
+}
+
+// Is matches the imported error, but not the errors it wraps.
+func (e *importedError) Is(target error) bool {
+	if sameError(e.err, target) {
+		return true
+	}
+	x, ok := e.err.(interface{ Is(error) bool })
+	return ok && x.Is(target)
+}
+
+// As matches the imported error, but not the errors it wraps.
+func (e *importedError) As(target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if reflect.TypeOf(e.err).AssignableTo(val.Type().Elem()) {
+		val.Elem().Set(reflect.ValueOf(e.err))
+		return true
+	}
+	x, ok := e.err.(interface{ As(interface{}) bool })
+	return ok && x.As(target)
+}
+
+// limitCauses returns err, with its chain of causes cut off after n causes.  The layers
+// above the last kept cause are copied without their causes, and relinked.  The last kept
+// cause isn't copied, so it keeps its identity, but its own causes are hidden.
+func limitCauses(err error, n int) error {
+	cause := Cause(err)
+	if cause == nil {
+		return err
+	}
+	if n <= 0 {
+		return &limitedCause{err: err, view: withoutCauses(err)}
+	}
+	limited := limitCauses(cause, n-1)
+	if sameError(limited, cause) {
+		return err
+	}
+	return &errWithCause{err: withoutCauses(err), cause: limited}
+}
+
+// limitedCause is the last cause kept by limitCauses.  It matches the original cause with
+// errors.Is(), but unwraps to a copy of its layers without their causes.
+type limitedCause struct {
+	err  error
+	view error
+}
+
+func (e *limitedCause) Error() string {
+	return e.err.Error()
+}
+
+func (e *limitedCause) String() string {
+	return e.Error()
+}
+
+func (e *limitedCause) Format(f fmt.State, verb rune) {
+	Format(f, verb, e)
+}
+
+// Is matches the original cause.
+func (e *limitedCause) Is(target error) bool {
+	return sameError(e.err, target)
+}
+
+// Unwrap returns the original cause's layers, without its causes.
+func (e *limitedCause) Unwrap() error {
+	return e.view
+}
+
+// withoutCauses copies the layers of err created by this package, leaving out any causes.
+func withoutCauses(err error) error {
+	switch t := err.(type) {
+	case *errWithCause:
+		return withoutCauses(t.err)
+	case *errWithValue:
+		inner := withoutCauses(t.err)
+		if sameError(inner, t.err) {
+			return t
+		}
+		value := t.value
+		if t.key == errKeyHooked {
+			// the value is the error being wrapped
+			value = inner
+		}
+		return &errWithValue{err: inner, key: t.key, value: value}
+	case *formatError:
+		inner := withoutCauses(t.error)
+		if sameError(inner, t.error) {
+			return t
+		}
+		return &formatError{inner}
+	default:
+		return err
+	}
 }
 
 func (e *errWithCause) String() string {
@@ -133,7 +580,7 @@ func (e *errWithCause) String() string {
 }
 
 func (e *errWithCause) Error() string {
-	return e.err.Error()
+	return truncateMessage(e.err.Error())
 }
 
 func (e *errWithCause) Format(f fmt.State, verb rune) {
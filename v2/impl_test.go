@@ -55,6 +55,99 @@ func TestErrWithValue_Error(t *testing.T) {
 	assert.Equal(t, "blue", err.Error())
 }
 
+func TestMaxMessageLength(t *testing.T) {
+	defer SetMaxMessageLength(0)
+
+	SetMaxMessageLength(5)
+	assert.Equal(t, 5, MaxMessageLength())
+
+	// short messages are unaffected
+	assert.EqualError(t, New("blue"), "blue")
+	assert.EqualError(t, New("green"), "green")
+
+	// long messages are truncated, ending with an ellipsis
+	assert.EqualError(t, New("yellow"), "yell…")
+	assert.EqualError(t, Prepend(New("blue"), "big"), "big:…")
+	assert.EqualError(t, &errWithCause{err: errors.New("yellow"), cause: errors.New("red")}, "yell…")
+	assert.EqualError(t, Wrap(&UnwrapperError{errors.New("yellow")}), "yell…")
+
+	// length is measured in runes, not bytes
+	assert.EqualError(t, New("ééééé"), "ééééé")
+	assert.EqualError(t, New("éééééé"), "éééé…")
+
+	// 0 is unlimited
+	SetMaxMessageLength(0)
+	assert.EqualError(t, New("yellow"), "yellow")
+}
+
+// nonComparableError panics if compared with ==.
+type nonComparableError struct {
+	msgs []string
+}
+
+func (e nonComparableError) Error() string {
+	return "non-comparable"
+}
+
+func TestMaxCauseDepth(t *testing.T) {
+	defer SetMaxCauseDepth(0)
+
+	SetMaxCauseDepth(3)
+	assert.Equal(t, 3, MaxCauseDepth())
+
+	sentinels := make([]error, 10)
+	var err error
+	for i := range sentinels {
+		sentinels[i] = fmt.Errorf("attempt %d", i)
+		err = Wrap(sentinels[i], WithValue("attempt", i), WithCause(err))
+	}
+
+	var msgs []string
+	for c := err; c != nil; c = Cause(c) {
+		msgs = append(msgs, c.Error())
+	}
+	// the error itself, plus 3 causes
+	assert.Equal(t, []string{"attempt 9", "attempt 8", "attempt 7", "attempt 6"}, msgs)
+
+	// the oldest causes were dropped
+	assert.ErrorIs(t, err, sentinels[6])
+	assert.NotErrorIs(t, err, sentinels[5])
+	assert.NotErrorIs(t, err, sentinels[0])
+
+	// the values of the kept causes were kept
+	assert.Equal(t, 6, Value(Cause(Cause(Cause(err))), "attempt"))
+
+	// non-comparable errors are copied, rather than compared
+	nc := nonComparableError{}
+	ncErr := Wrap(nc, WithCause(Wrap(nc, WithCause(Wrap(nc, WithCause(nc))))))
+	ncErr = Wrap(errors.New("boom"), WithCause(ncErr))
+	assert.NotNil(t, Cause(Cause(Cause(ncErr))))
+	assert.Nil(t, Cause(Cause(Cause(Cause(ncErr)))))
+
+	// the last kept cause keeps its identity, but its causes are dropped
+	SetMaxCauseDepth(1)
+	root := errors.New("root")
+	sentinel := Sentinel("sentinel", WithCause(root))
+	limited := New("x", WithCause(sentinel))
+	assert.ErrorIs(t, limited, sentinel)
+	assert.NotErrorIs(t, limited, root)
+	assert.EqualError(t, Cause(limited), "sentinel")
+	assert.Nil(t, Cause(Cause(limited)))
+
+	// causes beneath foreign wrappers
+	limited = New("x", WithCause(fmt.Errorf("wrapped: %w", sentinel)))
+	assert.NotPanics(t, func() {
+		_ = Details(limited)
+		_ = fmt.Sprintf("%#v", limited)
+	})
+	assert.ErrorIs(t, limited, sentinel)
+
+	// 0 is unlimited
+	SetMaxCauseDepth(0)
+	err = Wrap(errors.New("boom"), WithCause(err))
+	assert.ErrorIs(t, err, sentinels[6])
+}
+
 func TestErrWithCause_Error(t *testing.T) {
 	err := &errWithCause{err: errors.New("blue"), cause: errors.New("red")}
 	assert.Equal(t, "blue", err.Error())
@@ -105,6 +198,19 @@ func TestErrWithCause_Unwrap(t *testing.T) {
 		unwrapped = errors.Unwrap(unwrapped)
 	}
 	assert.Equal(t, []string{"green", "blue", "yellow"}, layers)
+
+	// if the cause is also one of the wrapped layers, it has already been
+	// visited, so unwrapping skips ahead to its cause
+	rootCause := errors.New("red")
+	inner := Apply(errors.New("blue"), WithCause(rootCause))
+	unwrapped = Apply(inner, WithMessage("green"), WithCause(inner))
+	layers = nil
+	for unwrapped != nil {
+		layers = append(layers, unwrapped.Error())
+		unwrapped = errors.Unwrap(unwrapped)
+	}
+	assert.Equal(t, []string{"green", "blue", "red"}, layers)
+	assert.ErrorIs(t, Apply(inner, WithCause(inner)), rootCause)
 }
 
 func TestErrWithValue_String(t *testing.T) {
@@ -0,0 +1,34 @@
+// Package merryerrs provides sentinel errors for common failures, with HTTP and gRPC codes
+// attached, so they are rendered correctly by both transports:
+//
+//	if user == nil {
+//		return merry.Wrap(merryerrs.ErrNotFound, merry.WithUserMessage("no such user"))
+//	}
+//
+// Wrapping a sentinel captures a stack, and errors.Is() matches the wrapped error against
+// the sentinel.  merry.HTTPCode() and status.Code() return the attached codes.
+package merryerrs
+
+import (
+	"net/http"
+
+	"github.com/ansel1/merry/v2"
+	status "github.com/ansel1/merry/v2/grpcstatus"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrBadRequest is returned when a request is invalid.
+	ErrBadRequest = merry.Sentinel("bad request", merry.WithHTTPCode(http.StatusBadRequest), status.WithCode(codes.InvalidArgument))
+	// ErrUnauthorized is returned when a request isn't authenticated.
+	ErrUnauthorized = merry.Sentinel("unauthorized", merry.WithHTTPCode(http.StatusUnauthorized), status.WithCode(codes.Unauthenticated))
+	// ErrForbidden is returned when the caller isn't allowed to perform a request.
+	ErrForbidden = merry.Sentinel("forbidden", merry.WithHTTPCode(http.StatusForbidden), status.WithCode(codes.PermissionDenied))
+	// ErrNotFound is returned when a requested resource doesn't exist.
+	ErrNotFound = merry.Sentinel("not found", merry.WithHTTPCode(http.StatusNotFound), status.WithCode(codes.NotFound))
+	// ErrConflict is returned when a request conflicts with the current state of a resource,
+	// e.g. it already exists.
+	ErrConflict = merry.Sentinel("conflict", merry.WithHTTPCode(http.StatusConflict), status.WithCode(codes.AlreadyExists))
+	// ErrNotImplemented is returned when a request isn't supported.
+	ErrNotImplemented = merry.Sentinel("not implemented", merry.WithHTTPCode(http.StatusNotImplemented), status.WithCode(codes.Unimplemented))
+)
@@ -0,0 +1,40 @@
+package merryerrs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ansel1/merry/v2"
+	status "github.com/ansel1/merry/v2/grpcstatus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestSentinels(t *testing.T) {
+	tests := []struct {
+		err      error
+		msg      string
+		httpCode int
+		grpcCode codes.Code
+	}{
+		{ErrBadRequest, "bad request", 400, codes.InvalidArgument},
+		{ErrUnauthorized, "unauthorized", 401, codes.Unauthenticated},
+		{ErrForbidden, "forbidden", 403, codes.PermissionDenied},
+		{ErrNotFound, "not found", 404, codes.NotFound},
+		{ErrConflict, "conflict", 409, codes.AlreadyExists},
+		{ErrNotImplemented, "not implemented", 501, codes.Unimplemented},
+	}
+
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			assert.Empty(t, merry.Stack(test.err))
+
+			err := merry.Wrap(test.err, merry.WithUserMessage("oops"))
+			assert.EqualError(t, err, test.msg)
+			assert.True(t, errors.Is(err, test.err))
+			assert.NotEmpty(t, merry.Stack(err))
+			assert.Equal(t, test.httpCode, merry.HTTPCode(err))
+			assert.Equal(t, test.grpcCode, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Package merryhttp provides helpers for rendering merry errors as HTTP responses.
+package merryhttp
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ansel1/merry/v2"
+)
+
+// WriteError writes err to w as an HTTP error response.  If err has a retry delay attached
+// with merry.WithRetryAfter, a Retry-After header is set, in whole seconds, rounded up.
+// The response headers attached to err with merry.WithHTTPHeaders are added to the
+// response, and take precedence over the Retry-After header.  The status code is
+// merry.HTTPCode(err), or 500 if the error has no code, and the body is
+// merry.UserMessage(err).  If err has no user message, the body is the standard text
+// for the status code.  If err has a hint attached with merry.WithHint, it is written
+// on the next line.  The error's message is never written, since it is not safe
+// to show to end users.
+//
+// If err is nil, this is a no-op.
+func WriteError(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+
+	headers := merry.HTTPHeaders(err)
+
+	if d, ok := merry.RetryAfter(err); ok && headers.Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10))
+	}
+
+	for k, vs := range headers {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+
+	code := merry.HTTPCode(err)
+	if code == 0 {
+		// merry.HTTPCodeDefaulting() is off, and no code is attached
+		code = http.StatusInternalServerError
+	}
+	msg := merry.UserMessage(err)
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	if hint := merry.Hint(err); hint != "" {
+		msg += "\n" + hint
+	}
+
+	http.Error(w, msg, code)
+}
+
+// problemDetails is the body of an application/problem+json response, as defined by RFC 7807.
+type problemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ProblemDetails renders err as an RFC 7807 problem details JSON body, and returns it with
+// its content type, "application/problem+json".  The members are derived from err:
+//
+//   - type: "about:blank"
+//   - title: merry.KindOf(err), or the standard text for the status code if err has no kind
+//   - status: the status code, as written by WriteError
+//   - detail: merry.UserMessage(err), omitted if err has no user message
+//
+// instance is always omitted, since errors don't record the request they occurred in.  Like
+// WriteError, the error's message is never included, since it is not safe to show to end
+// users.
+//
+// If err is nil, returns nil and "".
+func ProblemDetails(err error) ([]byte, string) {
+	if err == nil {
+		return nil, ""
+	}
+
+	code := merry.HTTPCode(err)
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	title := merry.KindOf(err)
+	if title == "" {
+		title = http.StatusText(code)
+	}
+
+	body, jsonErr := json.Marshal(problemDetails{
+		Type:   "about:blank",
+		Title:  title,
+		Status: code,
+		Detail: merry.UserMessage(err),
+	})
+	if jsonErr != nil {
+		// can't happen: the struct only contains strings and ints
+		panic(jsonErr)
+	}
+	return body, "application/problem+json"
+}
+
+// Recoverer is HTTP middleware which recovers panics in next, converts them into errors
+// with merry.WrapRecover, and writes them to the response with WriteError.  The errors
+// have a 500 status code, and carry the stack from where the panic occurred.  Like
+// net/http does for unrecovered panics, the errors are logged, with their details, to
+// the standard logger.  Use RecovererFunc to handle them differently.
+//
+// Panics with http.ErrAbortHandler are not recovered, since they are used to abort
+// the response.
+func Recoverer(next http.Handler) http.Handler {
+	return RecovererFunc(next, logPanic)
+}
+
+// RecovererFunc is like Recoverer, but instead of logging the errors, it calls onPanic
+// with the request and the error, before the error is written to the response.  If
+// onPanic is nil, the errors are only written to the response.
+func RecovererFunc(next http.Handler, onPanic func(r *http.Request, err error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				err := merry.WrapRecover(rec)
+				if onPanic != nil {
+					onPanic(r, err)
+				}
+				WriteError(w, err)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logPanic(r *http.Request, err error) {
+	log.Printf("merryhttp: panic serving %s: %+v", r.URL.Path, err)
+}
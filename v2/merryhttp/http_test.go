@@ -0,0 +1,146 @@
+package merryhttp
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemDetails(t *testing.T) {
+	body, contentType := ProblemDetails(nil)
+	assert.Nil(t, body)
+	assert.Empty(t, contentType)
+
+	body, contentType = ProblemDetails(merry.New("user 5 not in db",
+		merry.WithHTTPCode(http.StatusNotFound),
+		merry.WithKind("UserNotFound"),
+		merry.WithUserMessage("No such user"),
+	))
+	assert.Equal(t, "application/problem+json", contentType)
+	assert.JSONEq(t, `{"type":"about:blank","title":"UserNotFound","status":404,"detail":"No such user"}`, string(body))
+
+	// without a kind or user message, the title is the status text, and detail is omitted
+	body, _ = ProblemDetails(merry.New("secret"))
+	assert.JSONEq(t, `{"type":"about:blank","title":"Internal Server Error","status":500}`, string(body))
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteError(w, merry.New("boom",
+		merry.WithHTTPCode(http.StatusUnauthorized),
+		merry.WithUserMessage("log in first"),
+		merry.WithHTTPHeaders(http.Header{"Www-Authenticate": {`Basic realm="app"`}}),
+	))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="app"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "log in first\n", w.Body.String())
+
+	// hints are written after the user message
+	w = httptest.NewRecorder()
+	WriteError(w, merry.New("boom", merry.WithHTTPCode(http.StatusUnauthorized), merry.WithUserMessage("log in first"), merry.WithHint("run `app login`")))
+	assert.Equal(t, "log in first\nrun `app login`\n", w.Body.String())
+
+	// without a user message, the status text is written, not the error message
+	w = httptest.NewRecorder()
+	WriteError(w, merry.New("secret"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "Internal Server Error\n", w.Body.String())
+
+	// with HTTP code defaulting off, errors without a code are still written as 500
+	merry.SetHTTPCodeDefaulting(false)
+	w = httptest.NewRecorder()
+	WriteError(w, merry.New("secret"))
+	merry.SetHTTPCodeDefaulting(true)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// retry delays are written in whole seconds
+	w = httptest.NewRecorder()
+	WriteError(w, merry.New("boom", merry.WithHTTPCode(http.StatusTooManyRequests), merry.WithRetryAfter(1500*time.Millisecond)))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// explicit headers take precedence
+	w = httptest.NewRecorder()
+	WriteError(w, merry.New("boom", merry.WithRetryAfter(time.Second), merry.WithHTTPHeaders(http.Header{"Retry-After": {"60"}})))
+	assert.Equal(t, []string{"60"}, w.Header().Values("Retry-After"))
+
+	// nil is a no-op
+	w = httptest.NewRecorder()
+	WriteError(w, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestRecoverer(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	h := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("bang")
+	}))
+
+	w := httptest.NewRecorder()
+	assert.NotPanics(t, func() {
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "Internal Server Error\n", w.Body.String())
+
+	// the error is logged with its stack
+	assert.Contains(t, logged.String(), "merryhttp: panic serving /users: panic: bang")
+	assert.Contains(t, logged.String(), "TestRecoverer")
+
+	// errors which describe the response are rendered
+	h = Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(merry.New("boom", merry.WithHTTPCode(http.StatusServiceUnavailable), merry.WithUserMessage("try later")))
+	}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "try later\n", w.Body.String())
+
+	// aborts are not recovered
+	h = Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
+
+func TestRecovererFunc(t *testing.T) {
+	var recovered error
+	var path string
+	h := RecovererFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("bang")
+	}), func(r *http.Request, err error) {
+		path = r.URL.Path
+		recovered = err
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "/users", path)
+	assert.EqualError(t, recovered, "panic: bang")
+	assert.True(t, merry.HasStack(recovered))
+
+	// without a callback, the error is only written to the response
+	h = RecovererFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("bang")
+	}), nil)
+	w = httptest.NewRecorder()
+	assert.NotPanics(t, func() {
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,18 @@
+module github.com/ansel1/merry/v2/merryotel
+
+go 1.18
+
+require (
+	github.com/ansel1/merry/v2 v2.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.8.3
+	go.opentelemetry.io/otel/trace v1.11.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.opentelemetry.io/otel v1.11.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/ansel1/merry/v2 => ../
@@ -0,0 +1,54 @@
+// Package merryotel integrates merry errors with OpenTelemetry tracing.
+//
+// This package is a separate module, so only programs which use it depend on
+// OpenTelemetry.
+package merryotel
+
+import (
+	"context"
+
+	"github.com/ansel1/merry/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The keys of the values attached by FromSpanContext.  They are strings, so they are
+// included in merry.Fields(), for structured logs.
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+func init() {
+	merry.RegisterDetail("Trace ID", TraceIDKey)
+	merry.RegisterDetail("Span ID", SpanIDKey)
+}
+
+// FromSpanContext attaches the trace ID and span ID of the span in ctx to the error, so
+// the error can be correlated with the trace in logs.  The IDs are attached as hex strings,
+// with the keys TraceIDKey and SpanIDKey, and are printed by merry.Details().
+//
+// If ctx has no valid span context, this is a no-op.
+func FromSpanContext(ctx context.Context) merry.Wrapper {
+	return merry.WrapperFunc(func(err error, callerDepth int) error {
+		sc := trace.SpanContextFromContext(ctx)
+		if err == nil || !sc.IsValid() {
+			return err
+		}
+		return merry.ApplySkipping(err, callerDepth+1,
+			merry.WithValue(TraceIDKey, sc.TraceID().String()),
+			merry.WithValue(SpanIDKey, sc.SpanID().String()),
+		)
+	})
+}
+
+// TraceID returns the trace ID attached to err by FromSpanContext, or "" if none is attached.
+func TraceID(err error) string {
+	id, _ := merry.Value(err, TraceIDKey).(string)
+	return id
+}
+
+// SpanID returns the span ID attached to err by FromSpanContext, or "" if none is attached.
+func SpanID(err error) string {
+	id, _ := merry.Value(err, SpanIDKey).(string)
+	return id
+}
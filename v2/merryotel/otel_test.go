@@ -0,0 +1,38 @@
+package merryotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestFromSpanContext(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+		SpanID:  trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	err := merry.New("boom", FromSpanContext(ctx))
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", TraceID(err))
+	assert.Equal(t, "0102030405060708", SpanID(err))
+	assert.Equal(t, map[string]interface{}{
+		"trace_id": "0102030405060708090a0b0c0d0e0f10",
+		"span_id":  "0102030405060708",
+	}, merry.Fields(err))
+	assert.Contains(t, merry.Details(err), "Trace ID: 0102030405060708090a0b0c0d0e0f10")
+	assert.Contains(t, merry.Details(err), "Span ID: 0102030405060708")
+
+	// no span -> no-op
+	err = merry.New("boom", FromSpanContext(context.Background()))
+	assert.Empty(t, TraceID(err))
+	assert.Empty(t, SpanID(err))
+
+	// nil -> nil
+	assert.Nil(t, FromSpanContext(ctx).Wrap(nil, 0))
+	assert.Empty(t, TraceID(errors.New("boom")))
+}
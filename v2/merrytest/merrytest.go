@@ -0,0 +1,61 @@
+// Package merrytest provides test assertions for merry errors.
+package merrytest
+
+import (
+	"fmt"
+
+	"github.com/ansel1/merry/v2"
+)
+
+// TestingT is the subset of *testing.T used by the assertions.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// AssertEqual asserts that actual is equal to expected, ignoring their stacks, as compared
+// by merry.Equal.  Expected errors can be constructed like the errors the code under test
+// is expected to return:
+//
+//	merrytest.AssertEqual(t, merry.New("not found", merry.WithHTTPCode(404)), err)
+//
+// If the errors aren't equal, the test fails with a message describing both errors.
+// msgAndArgs are added to the message, like testify's assertions.  Returns whether
+// the errors are equal.
+func AssertEqual(t TestingT, expected, actual error, msgAndArgs ...interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	if merry.Equal(expected, actual) {
+		return true
+	}
+
+	msg := fmt.Sprintf("errors not equal:\nexpected: %s\nactual:   %s", describe(expected), describe(actual))
+	if m := messageFromMsgAndArgs(msgAndArgs...); m != "" {
+		msg += "\nmessages: " + m
+	}
+	t.Errorf("%s", msg)
+	return false
+}
+
+// describe prints the error without its stack, since stacks aren't compared.
+func describe(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return merry.DetailsStable(err, merry.OmitStack)
+}
+
+func messageFromMsgAndArgs(msgAndArgs ...interface{}) string {
+	switch len(msgAndArgs) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%v", msgAndArgs[0])
+	default:
+		if format, ok := msgAndArgs[0].(string); ok {
+			return fmt.Sprintf(format, msgAndArgs[1:]...)
+		}
+		return fmt.Sprintf("%v", msgAndArgs)
+	}
+}
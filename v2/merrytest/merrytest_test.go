@@ -0,0 +1,42 @@
+package merrytest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ansel1/merry/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingT struct {
+	msgs []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestAssertEqual(t *testing.T) {
+	newErr := func() error {
+		return merry.New("not found", merry.WithHTTPCode(404), merry.WithCause(errors.New("no rows")))
+	}
+
+	rt := &recordingT{}
+	assert.True(t, AssertEqual(rt, newErr(), newErr()))
+	assert.Empty(t, rt.msgs)
+
+	assert.False(t, AssertEqual(rt, newErr(), merry.New("not found", merry.WithHTTPCode(400)), "lookup %d", 5))
+	if assert.Len(t, rt.msgs, 1) {
+		assert.Contains(t, rt.msgs[0], "errors not equal")
+		assert.Contains(t, rt.msgs[0], "HTTP Code: 404")
+		assert.Contains(t, rt.msgs[0], "HTTP Code: 400")
+		assert.Contains(t, rt.msgs[0], "messages: lookup 5")
+	}
+
+	rt = &recordingT{}
+	assert.False(t, AssertEqual(rt, nil, newErr()))
+	if assert.Len(t, rt.msgs, 1) {
+		assert.Contains(t, rt.msgs[0], "expected: <nil>")
+	}
+}
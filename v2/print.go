@@ -7,13 +7,15 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Location returns zero values if e has no stacktrace
 func Location(err error) (file string, line int) {
-	s := Stack(err)
+	s := markedStack(err)
 	if len(s) > 0 {
-		fnc, _ := runtime.CallersFrames(s[:1]).Next()
+		fnc, _ := resolveFrame(s[0])
 		return fnc.File, fnc.Line
 	}
 	return "", 0
@@ -23,15 +25,20 @@ func Location(err error) (file string, line int) {
 // Location's result or an empty string if there's
 // no stracktrace.
 func SourceLine(err error) string {
-	s := Stack(err)
+	s := markedStack(err)
 	if len(s) > 0 {
-		fnc, _ := runtime.CallersFrames(s[:1]).Next()
-		_, f := path.Split(fnc.File)
-		return fmt.Sprintf("%s (%s:%d)", fnc.Function, f, fnc.Line)
+		fnc, _ := resolveFrame(s[0])
+		return sourceLine(fnc)
 	}
 	return ""
 }
 
+// sourceLine formats a frame as the function name, followed by the file name and line.
+func sourceLine(frame runtime.Frame) string {
+	_, f := path.Split(frame.File)
+	return fmt.Sprintf("%s (%s:%d)", frame.Function, f, frame.Line)
+}
+
 // FormattedStack returns the stack attached to an error, formatted as a slice of strings.
 // Each string represents a frame in the stack, newest first.  The strings may
 // have internal newlines.
@@ -43,22 +50,172 @@ func FormattedStack(err error) []string {
 		return formattedStack
 	}
 
-	s := Stack(err)
-	if len(s) > 0 {
-		lines := make([]string, 0, len(s))
-
-		frames := runtime.CallersFrames(s)
-		for {
-			frame, more := frames.Next()
-			lines = append(lines, fmt.Sprintf("%s\n\t%s:%d", frame.Function, frame.File, frame.Line))
-			if !more {
-				break
-			}
+	return formatFrames(Frames(err), defaultFrameFormatter())
+}
+
+// Frames returns the frames of the stack attached to an error, newest first, resolved with
+// runtime.CallersFrames.  Inlined function calls have their own frames.  The frames elided
+// by CaptureStackHeadTail, and the end of stacks truncated at MaxStackDepth(), are marked
+// by a frame whose Function is "...", and whose File describes the missing frames.
+//
+// Returns nil if no stack is associated, or err is nil.
+func Frames(err error) []Frame {
+	s := markedStack(err)
+	if len(s) == 0 {
+		return nil
+	}
+	return resolveFrames(s)
+}
+
+// maxFrameCacheSize bounds the number of program counters in the frame cache.
+const maxFrameCacheSize = 4096
+
+// frameCache caches the frames resolved for program counters.  Errors created
+// at the same call sites share most of their frames, so formatting their stacks
+// resolves the same program counters over and over.  Entries are only added, so
+// formatting stacks doesn't take any locks once the frames are cached.
+var frameCache sync.Map // map[uintptr]runtime.Frame
 
+// frameCacheSize counts the entries in the frame cache.
+var frameCacheSize int32
+
+// resolveFrame returns the frame for a program counter captured by runtime.Callers.
+// Returns false if the program counter can't be resolved.
+func resolveFrame(pc uintptr) (runtime.Frame, bool) {
+	if pc == elidedFrames || pc == truncatedFrames {
+		return runtime.Frame{}, false
+	}
+	frames := appendFrames(nil, []uintptr{pc})
+	if len(frames) == 0 {
+		return runtime.Frame{}, false
+	}
+	return frames[0], true
+}
+
+// resolveFrames resolves the frames of a stack, replacing the elided and truncated
+// frames markers with marker frames.
+func resolveFrames(s []uintptr) []runtime.Frame {
+	frames := make([]runtime.Frame, 0, len(s))
+	for len(s) > 0 {
+		n := 0
+		for n < len(s) && s[n] != elidedFrames && s[n] != truncatedFrames {
+			n++
 		}
-		return lines
+		frames = appendFrames(frames, s[:n])
+		if n < len(s) {
+			frames = append(frames, markerFrame(s[n]))
+			n++
+		}
+		s = s[n:]
+	}
+	return frames
+}
+
+// appendFrames resolves program counters captured by runtime.Callers, and appends their
+// frames to frames.  They are read from the cache if they are all cached.  Otherwise,
+// they are all resolved with a single runtime.CallersFrames iteration.
+//
+// runtime.Callers returns a program counter for each inlined function call, so each
+// program counter resolves to a single frame, and they can be cached individually.
+// Stacks which only contain the program counters of physical frames resolve to more
+// frames than program counters, and aren't cached.
+func appendFrames(frames []runtime.Frame, pcs []uintptr) []runtime.Frame {
+	if len(pcs) == 0 {
+		return frames
 	}
-	return nil
+
+	start := len(frames)
+	for _, pc := range pcs {
+		frame, ok := frameCache.Load(pc)
+		if !ok {
+			break
+		}
+		frames = append(frames, frame.(runtime.Frame))
+	}
+	if len(frames)-start == len(pcs) {
+		return frames
+	}
+
+	frames = frames[:start]
+	iter := runtime.CallersFrames(pcs)
+	for {
+		frame, more := iter.Next()
+		if frame.PC != 0 {
+			frames = append(frames, frame)
+		}
+		if !more {
+			break
+		}
+	}
+
+	resolved := frames[start:]
+	if len(resolved) != len(pcs) {
+		return frames
+	}
+
+	for i, pc := range pcs {
+		// the cache is rarely full, unless there is an unbounded number
+		// of call sites, in which case the cache isn't useful anyway.
+		if atomic.LoadInt32(&frameCacheSize) >= maxFrameCacheSize {
+			break
+		}
+		if _, loaded := frameCache.LoadOrStore(pc, resolved[i]); !loaded {
+			atomic.AddInt32(&frameCacheSize, 1)
+		}
+	}
+
+	return frames
+}
+
+// markerFrame returns the frame which takes the place of a marker in a stack: the frames
+// elided by CaptureStackHeadTail, or the end of a stack truncated at MaxStackDepth().
+func markerFrame(marker uintptr) runtime.Frame {
+	frame := runtime.Frame{PC: marker, Function: "..."}
+	switch marker {
+	case elidedFrames:
+		frame.File = "(frames elided)"
+	case truncatedFrames:
+		frame.File = "(stack truncated, increase MaxStackDepth)"
+	}
+	return frame
+}
+
+// isMarkerFrame returns true if frame was returned by markerFrame.
+func isMarkerFrame(frame runtime.Frame) bool {
+	return frame.Function == "..." && (frame.PC == elidedFrames || frame.PC == truncatedFrames)
+}
+
+// defaultFrameFormatter returns the FrameFormatter(), or the default format: the
+// function name, followed by the absolute file path and line.
+func defaultFrameFormatter() func(frame runtime.Frame) string {
+	if f := FrameFormatter(); f != nil {
+		return f
+	}
+	return func(frame runtime.Frame) string {
+		return fmt.Sprintf("%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+	}
+}
+
+// formatStack resolves the frames of a stack, and formats them with defaultFrameFormatter().
+func formatStack(s []uintptr) []string {
+	return formatFrames(resolveFrames(s), defaultFrameFormatter())
+}
+
+// formatFrames formats each frame.  Marker frames are formatted as the marker's line.
+func formatFrames(frames []runtime.Frame, format func(frame runtime.Frame) string) []string {
+	if len(frames) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		if isMarkerFrame(frame) {
+			lines = append(lines, frame.Function+"\n\t"+frame.File)
+		} else {
+			lines = append(lines, format(frame))
+		}
+	}
+	return lines
 }
 
 // Stacktrace returns the error's stacktrace as a string formatted.
@@ -67,47 +224,383 @@ func Stacktrace(err error) string {
 	return strings.Join(FormattedStack(err), "\n")
 }
 
+// ParseFormattedStack parses a stacktrace formatted by Stacktrace(), e.g. one read back from
+// a log, into the frames returned by FormattedStack().  Lines indented with a tab are joined
+// to the frame on the line before.  The result can be attached to an error with
+// WithFormattedStack, so the error's stacktrace is rendered identically:
+//
+//	err = merry.Wrap(err, merry.WithFormattedStack(merry.ParseFormattedStack(logged)))
+//	merry.Stacktrace(err) == logged
+//
+// Returns nil if s is empty.
+func ParseFormattedStack(s string) []string {
+	var frames []string
+
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "\t") && len(frames) > 0:
+			frames[len(frames)-1] += "\n" + line
+		default:
+			frames = append(frames, line)
+		}
+	}
+
+	return frames
+}
+
+// DebugString returns a description of the internal structure of an error, for diagnosing
+// problems with this package, e.g. why a value isn't found.  Each layer in the chain of wrapped
+// errors is printed on its own line, outermost first, with its concrete type.  Layers which
+// attach values also print the key and value, and layers which attach causes print the
+// cause's chain beneath them, indented.  Layers not created by this package are marked
+// "(non-merry)", and print their message.
+//
+// The output format is not stable, and should not be parsed.  To print an error's details
+// for end users or logs, use Details().
+//
+// If err is nil, returns "".
+func DebugString(err error) string {
+	var sb strings.Builder
+	debugString(&sb, err, "")
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func debugString(sb *strings.Builder, err error, indent string) {
+	for layer := err; layer != nil; layer = unwrapLayer(layer) {
+		sb.WriteString(indent)
+		fmt.Fprintf(sb, "%T", layer)
+
+		switch e := layer.(type) {
+		case *errWithValue:
+			fmt.Fprintf(sb, " %v=%s\n", debugKey(e.key), debugValue(e.value))
+		case *errWithCause:
+			sb.WriteString("\n" + indent + "  cause:\n")
+			debugString(sb, e.cause, indent+"    ")
+		case *formatError:
+			sb.WriteString("\n")
+		default:
+			fmt.Fprintf(sb, " (non-merry) %q\n", layer.Error())
+		}
+	}
+}
+
+func debugKey(key interface{}) string {
+	if k, ok := key.(errKey); ok {
+		// internal keys
+		return k.String()
+	}
+	return fmt.Sprintf("%v(%T)", key, key)
+}
+
+func debugValue(value interface{}) string {
+	if s, ok := value.(*capturedStack); ok {
+		value = s.stack
+	}
+	switch v := value.(type) {
+	case []uintptr:
+		return fmt.Sprintf("[%d frames]", len(v))
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// DetailsTree renders the structure of an error as an indented tree, to show which layer
+// of the error added which message or value.  The first line is the error's message.  Beneath
+// it, each layer in the chain of wrapped errors is printed on its own line, outermost first:
+// the messages and values added by the layer, the location of an attached stack, or the
+// message of an error from another package.  Causes are printed as sub-trees, indented
+// beneath the layer which attached them.  Values masked by WithoutValue are printed as "<removed>".
+//
+// Like Details, it is meant for people reading logs, not for parsing.
+//
+// If err is nil, returns "".
+func DetailsTree(err error) string {
+	if err == nil {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(err.Error())
+	detailsTree(&sb, err, "  ")
+	return sb.String()
+}
+
+func detailsTree(sb *strings.Builder, err error, indent string) {
+	for layer := err; layer != nil; layer = unwrapLayer(layer) {
+		switch e := layer.(type) {
+		case *errWithValue:
+			if e.key == errKeyHooked {
+				// bookkeeping, not context
+				continue
+			}
+			sb.WriteString("\n" + indent + treeKey(e.key) + ": " + treeValue(e.key, e.value))
+		case *errWithCause:
+			sb.WriteString("\n" + indent + "cause: " + e.cause.Error())
+			detailsTree(sb, e.cause, indent+"  ")
+		case *formatError, *limitedCause:
+		case *importedError:
+			fmt.Fprintf(sb, "\n%s%T: %s", indent, e.err, e.Error())
+		default:
+			if _, ok := layer.(interface{ Unwrap() error }); ok {
+				fmt.Fprintf(sb, "\n%s%T: %s", indent, layer, layer.Error())
+			} else {
+				sb.WriteString("\n" + indent + "root: " + layer.Error())
+			}
+		}
+	}
+}
+
+func treeKey(key interface{}) string {
+	if s, ok := key.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%v", key)
+}
+
+func treeValue(key, value interface{}) string {
+	if key == errKeyStack && value == nil {
+		// NoCaptureStack
+		return "none"
+	}
+
+	if s, ok := value.(*capturedStack); ok {
+		value = s.stack
+	}
+	switch v := value.(type) {
+	case []uintptr:
+		if len(v) == 0 {
+			return "none"
+		}
+		if frame, ok := resolveFrame(v[0]); ok {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, path.Base(frame.File), frame.Line)
+		}
+		return fmt.Sprintf("[%d frames]", len(v))
+	case []string:
+		return fmt.Sprintf("[%d frames]", len(v))
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// VerboseError returns the details of err, as printed by Details().  It is meant for
+// logging sinks which should always print the details of errors, rather than just their
+// messages.  It is the recommended alternative to the global verbose setting of merry v1,
+// and to AlwaysVerbose().
+//
+// If err is nil, returns "".
+func VerboseError(err error) string {
+	return Details(err)
+}
+
+// AsUserMessage returns a fmt.Stringer which prints the error's user message, as returned
+// by UserMessage(), so templates and format strings can include the message which is safe
+// to show to end users, rather than the error's message:
+//
+//	log.Printf("request failed: %s", merry.AsUserMessage(err))
+//
+// Prints nothing if err has no user message, or is nil.
+func AsUserMessage(err error) fmt.Stringer {
+	return userMessageStringer{err: err}
+}
+
+type userMessageStringer struct {
+	err error
+}
+
+func (s userMessageStringer) String() string {
+	return UserMessage(s.err)
+}
+
 // Details returns e.Error(), e's stacktrace, and any additional details which have
 // be registered with RegisterDetail.  User message and HTTP code are already registered.
+// Breadcrumbs are listed before the stacktrace.  Boundary stacks attached with AddStack()
+// are listed after it, under "crossed boundary at:", followed by named stacks attached with
+// WithNamedStack(), under their labels.
 //
-// The details of each error in e's cause chain will also be printed.
+// The details of each error in e's cause chain will also be printed.  If a cause's stack
+// is a prefix or suffix of a stack already printed above it, e.g. because the cause was
+// created in the same place, it is printed as "(stack included above)".
 func Details(e error) string {
+	return details(e, false, 0, nil)
+}
+
+// StableOption configures the output of DetailsStable.
+type StableOption int
+
+const (
+	// OmitLineNumbers omits line numbers from the stacktrace.
+	OmitLineNumbers StableOption = 1 << iota
+	// OmitStack omits the stacktrace entirely.
+	OmitStack
+)
+
+// DetailsStable is like Details, but produces output which is stable across machines
+// and builds, suitable for comparing against golden files in tests.  Absolute source
+// file paths in the stacktrace are replaced with the path of the file's package, e.g.
+// "github.com/ansel1/merry/v2/print.go".  Line numbers, or the entire stacktrace, can be
+// omitted with options:
+//
+//	merry.DetailsStable(err, merry.OmitLineNumbers)
+//
+// Pre-formatted stacks attached with WithFormattedStack are printed as-is.
+func DetailsStable(e error, opts ...StableOption) string {
+	var o StableOption
+	for _, opt := range opts {
+		o |= opt
+	}
+	return details(e, true, o, nil)
+}
+
+// details formats the details of e.  printed are the stacks of the outer errors, which
+// have already been printed.
+func details(e error, stable bool, opts StableOption, printed [][]uintptr) string {
 	if e == nil {
 		return ""
 	}
 
+	// skip AlwaysVerbose() layers, which print the details of the errors they wrap
+	for {
+		v, ok := e.(*errWithValue)
+		if !ok || v.key != errKeyVerbose {
+			break
+		}
+		e = v.err
+	}
+
 	msg := e.Error()
 	var dets []string
 
-	detailsLock.Lock()
-
-	for label, f := range detailFields {
+	for label, f := range registeredDetailFields() {
 		v := f(e)
 		if v != nil {
 			dets = append(dets, fmt.Sprintf("%s: %v", label, v))
 		}
 	}
 
-	detailsLock.Unlock()
-
 	if len(dets) > 0 {
 		// sort so output is predictable
 		sort.Strings(dets)
 		msg += "\n" + strings.Join(dets, "\n")
 	}
 
-	s := Stacktrace(e)
+	if crumbs := breadcrumbs(e); len(crumbs) > 0 {
+		msg += "\n\nBreadcrumbs:"
+		for _, b := range crumbs {
+			if opts&OmitLineNumbers != 0 {
+				b.line = 0
+			}
+			msg += "\n\t" + b.String()
+		}
+	}
+
+	hideStack, _ := Value(e, errKeyHideStack).(bool)
+
+	var s string
+	stack := markedStack(e)
+	switch {
+	case hideStack, stable && opts&OmitStack != 0:
+	case len(stack) > 0 && coveredStack(printed, stack):
+		// don't print the same frames twice, e.g. for a cause created in
+		// the same place as the error
+		s = "(stack included above)"
+	case !stable:
+		s = Stacktrace(e)
+	default:
+		s = stableStacktrace(e, opts&OmitLineNumbers == 0)
+	}
 	if s != "" {
 		msg += "\n\n" + s
 	}
 
+	if !hideStack && (!stable || opts&OmitStack == 0) {
+		formatStack := formatStack
+		if stable {
+			formatStack = func(s []uintptr) []string {
+				return stableFrames(s, opts&OmitLineNumbers == 0)
+			}
+		}
+
+		for _, bs := range boundaryStacks(e) {
+			msg += "\n\ncrossed boundary at:\n" + strings.Join(formatStack(bs), "\n")
+		}
+
+		named := NamedStacks(e)
+		labels := make([]string, 0, len(named))
+		for label := range named {
+			labels = append(labels, label)
+		}
+		// sort so output is predictable
+		sort.Strings(labels)
+		for _, label := range labels {
+			if len(named[label]) > 0 {
+				msg += "\n\n" + label + ":\n" + strings.Join(formatStack(named[label]), "\n")
+			}
+		}
+	}
+
+	if !hideStack && len(stack) > 0 {
+		printed = append(printed[:len(printed):len(printed)], stack)
+	}
+
+	if suppressed := Suppressed(e); len(suppressed) > 0 {
+		msg += "\n\nSuppressed:"
+		for _, s := range suppressed {
+			msg += "\n\t" + strings.ReplaceAll(details(s, stable, opts, printed), "\n", "\n\t")
+		}
+	}
+
 	if c := Cause(e); c != nil {
-		msg += "\n\nCaused By: " + Details(c)
+		msg += "\n\nCaused By: " + details(c, stable, opts, printed)
 	}
 
 	return msg
 }
 
+// stableStacktrace is like Stacktrace, but replaces absolute file paths with the package path.
+func stableStacktrace(err error, withLines bool) string {
+	if formattedStack, _ := Value(err, errKeyStack).([]string); len(formattedStack) > 0 {
+		return strings.Join(formattedStack, "\n")
+	}
+
+	s := markedStack(err)
+	if len(s) == 0 {
+		return ""
+	}
+
+	return strings.Join(stableFrames(s, withLines), "\n")
+}
+
+// stableFrames formats the frames of a stack, using the package path instead of the
+// absolute file path.
+func stableFrames(s []uintptr, withLines bool) []string {
+	return formatFrames(resolveFrames(s), func(frame runtime.Frame) string {
+		file := packagePath(frame.Function) + "/" + path.Base(frame.File)
+		if withLines {
+			return fmt.Sprintf("%s\n\t%s:%d", frame.Function, file, frame.Line)
+		}
+		return fmt.Sprintf("%s\n\t%s", frame.Function, file)
+	})
+}
+
+// packagePath returns the package path portion of a fully qualified function name, e.g.
+// "github.com/ansel1/merry/v2.New" -> "github.com/ansel1/merry/v2"
+//
+// The runtime escapes the dots in the last element of the package path, e.g.
+// "gopkg.in/yaml%2ev3.Unmarshal", so the package path ends at the first dot after
+// the last slash.
+func packagePath(function string) string {
+	pkg := function
+	lastSlash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[lastSlash+1:], "."); dot >= 0 {
+		pkg = function[:lastSlash+1+dot]
+	}
+	return strings.ReplaceAll(pkg, "%2e", ".")
+}
+
 // Format adapts errors to fmt.Formatter interface.  It's intended to be used
 // help error impls implement fmt.Formatter, e.g.:
 //
@@ -4,9 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -92,4 +95,336 @@ func TestDetails(t *testing.T) {
 	assert.Equal(t, "bang", lines[0])
 	assert.Contains(t, deets, Stacktrace(err))
 	assert.Contains(t, deets, "User Message: stay calm")
+
+	// breadcrumbs are listed
+	err = Wrap(err, Breadcrumb("passed"))
+	deets = Details(err)
+	assert.Contains(t, deets, "\n\nBreadcrumbs:\n\tpassed: github.com/ansel1/merry/v2.TestDetails (print_test.go:")
+}
+
+func TestDetailsStable(t *testing.T) {
+	// nil -> empty
+	assert.Empty(t, DetailsStable(nil))
+
+	_, _, rl, _ := runtime.Caller(0)
+	err := New("bang", WithUserMessage("stay calm"), Breadcrumb("created"))
+	err = Wrap(err, WithCause(New("bam", NoCaptureStack())))
+
+	deets := DetailsStable(err)
+	lines := strings.Split(deets, "\n")
+	assert.Equal(t, []string{
+		"bang",
+		"User Message: stay calm",
+		"",
+		"Breadcrumbs:",
+		fmt.Sprintf("\tcreated: github.com/ansel1/merry/v2.TestDetailsStable (print_test.go:%d)", rl+1),
+		"",
+		"github.com/ansel1/merry/v2.TestDetailsStable",
+		fmt.Sprintf("\tgithub.com/ansel1/merry/v2/print_test.go:%d", rl+1),
+	}, lines[:8])
+	assert.Equal(t, "Caused By: bam", lines[len(lines)-1])
+
+	// without line numbers
+	deets = DetailsStable(err, OmitLineNumbers)
+	assert.Contains(t, deets, "\tcreated: github.com/ansel1/merry/v2.TestDetailsStable (print_test.go)\n")
+	assert.Contains(t, deets, "github.com/ansel1/merry/v2.TestDetailsStable\n\tgithub.com/ansel1/merry/v2/print_test.go\n")
+	assert.NotContains(t, deets, "print_test.go:")
+
+	// without stack
+	assert.Equal(t, "bang\nUser Message: stay calm\n\nBreadcrumbs:\n\tcreated: github.com/ansel1/merry/v2.TestDetailsStable (print_test.go)\n\nCaused By: bam",
+		DetailsStable(err, OmitLineNumbers, OmitStack))
+}
+
+func TestPackagePath(t *testing.T) {
+	tests := []struct {
+		function, want string
+	}{
+		{"github.com/ansel1/merry/v2.New", "github.com/ansel1/merry/v2"},
+		{"github.com/ansel1/merry/v2.(*errWithValue).Error", "github.com/ansel1/merry/v2"},
+		{"github.com/ansel1/merry/v2.TestPackagePath.func1", "github.com/ansel1/merry/v2"},
+		{"gopkg.in/yaml%2ev3.Unmarshal", "gopkg.in/yaml.v3"},
+		{"gopkg.in/yaml%2ev3.(*decoder).unmarshal", "gopkg.in/yaml.v3"},
+		{"main.main", "main"},
+		{"main", "main"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, packagePath(test.function), test.function)
+	}
+}
+
+func TestDebugString(t *testing.T) {
+	assert.Empty(t, DebugString(nil))
+
+	err := New("boom", WithHTTPCode(404), WithValue("color", "red"))
+	err = fmt.Errorf("wrapped: %w", err)
+	err = Wrap(err, WithCause(errors.New("bang")), WithUserMessage("oops"))
+
+	assert.Equal(t, `*merry.errWithValue user message="oops"
+*merry.errWithCause
+  cause:
+    *errors.errorString (non-merry) "bang"
+*fmt.wrapError (non-merry) "wrapped: boom"
+*merry.errWithValue stack=[`+strconv.Itoa(len(Stack(err)))+` frames]
+*merry.errWithValue color(string)="red"
+*merry.errWithValue http status code=404
+*errors.errorString (non-merry) "boom"`, DebugString(err))
+}
+
+func TestAlwaysVerbose(t *testing.T) {
+	assert.Empty(t, VerboseError(nil))
+
+	err := New("boom", WithUserMessage("oops"))
+	assert.Equal(t, Details(err), VerboseError(err))
+
+	verbose := Wrap(err, AlwaysVerbose())
+	assert.Equal(t, Details(err), verbose.Error())
+	assert.Equal(t, Details(err), fmt.Sprintf("%v", verbose))
+	assert.Equal(t, Details(err), fmt.Sprintf("%+v", verbose))
+	assert.Equal(t, Details(err), Details(verbose))
+
+	// the original error is not affected
+	assert.EqualError(t, err, "boom")
+
+	// errors from other packages get a stack, which is printed
+	verbose = Wrap(errors.New("boom"), AlwaysVerbose())
+	assert.True(t, HasStack(verbose))
+	assert.Contains(t, verbose.Error(), "TestAlwaysVerbose")
+	assert.Equal(t, Details(verbose), verbose.Error())
+}
+
+func TestParseFormattedStack(t *testing.T) {
+	assert.Nil(t, ParseFormattedStack(""))
+
+	err := New("boom", CaptureStackHeadTail(1, 1))
+	logged := Stacktrace(err)
+
+	frames := ParseFormattedStack(logged)
+	assert.Equal(t, FormattedStack(err), frames)
+
+	// round trip
+	rehydrated := New("boom", WithFormattedStack(frames))
+	assert.Equal(t, logged, Stacktrace(rehydrated))
+
+	// windows line endings and trailing newlines are tolerated
+	assert.Equal(t, frames, ParseFormattedStack(strings.ReplaceAll(logged, "\n", "\r\n")+"\r\n"))
+}
+
+func TestSetFrameFormatter(t *testing.T) {
+	defer SetFrameFormatter(nil)
+
+	err := New("boom")
+	defaultStack := Stacktrace(err)
+
+	SetFrameFormatter(func(frame Frame) string {
+		return fmt.Sprintf("%s:%d", path.Base(frame.File), frame.Line)
+	})
+	assert.NotNil(t, FrameFormatter())
+
+	lines := FormattedStack(err)
+	require.NotEmpty(t, lines)
+	_, line := Location(err)
+	assert.Equal(t, "print_test.go:"+strconv.Itoa(line), lines[0])
+	assert.Equal(t, strings.Join(lines, "\n"), Stacktrace(err))
+	assert.Contains(t, Details(err), lines[0])
+
+	// nil restores the default
+	SetFrameFormatter(nil)
+	assert.Equal(t, defaultStack, Stacktrace(err))
+}
+
+func inlinedCaller() []uintptr {
+	return inlinedCallee()
+}
+
+func inlinedCallee() []uintptr {
+	s := make([]uintptr, 50)
+	return s[:runtime.Callers(1, s)]
+}
+
+func TestFrameCache(t *testing.T) {
+	stack := inlinedCaller()
+
+	var want []string
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		want = append(want, fmt.Sprintf("%s:%d", frame.Function, frame.Line))
+		if !more {
+			break
+		}
+	}
+
+	format := func(frame runtime.Frame) string {
+		return fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+	}
+
+	// the first pass populates the cache, the second reads from it
+	assert.Equal(t, want, formatFrames(resolveFrames(stack), format))
+	assert.Equal(t, want, formatFrames(resolveFrames(stack), format))
+
+	_, ok := resolveFrame(elidedFrames)
+	assert.False(t, ok)
+}
+
+func TestAsUserMessage(t *testing.T) {
+	err := New("db connection refused", WithUserMessage("try again later"))
+	assert.Equal(t, "failed: try again later", fmt.Sprintf("failed: %s", AsUserMessage(err)))
+	assert.Equal(t, "try again later", fmt.Sprintf("%v", AsUserMessage(err)))
+	assert.Equal(t, `"try again later"`, fmt.Sprintf("%q", AsUserMessage(err)))
+
+	assert.Empty(t, AsUserMessage(New("boom")).String())
+	assert.Empty(t, AsUserMessage(nil).String())
+}
+
+func TestFrames(t *testing.T) {
+	assert.Nil(t, Frames(nil))
+	assert.Nil(t, Frames(New("boom", NoCaptureStack())))
+
+	err := New("boom")
+	frames := Frames(err)
+	if assert.Len(t, frames, len(Stack(err))) {
+		assert.Equal(t, "github.com/ansel1/merry/v2.TestFrames", frames[0].Function)
+		file, line := Location(err)
+		assert.Equal(t, file, frames[0].File)
+		assert.Equal(t, line, frames[0].Line)
+	}
+
+	// markers are returned as frames
+	err = New("boom", WithStack([]uintptr{Stack(err)[0], elidedFrames, Stack(err)[1], truncatedFrames}))
+	frames = Frames(err)
+	if assert.Len(t, frames, 4) {
+		assert.Equal(t, "...", frames[1].Function)
+		assert.Equal(t, "(frames elided)", frames[1].File)
+		assert.Equal(t, "...", frames[3].Function)
+		assert.Equal(t, "(stack truncated, increase MaxStackDepth)", frames[3].File)
+	}
+	lines := FormattedStack(err)
+	assert.Equal(t, "...\n\t(frames elided)", lines[1])
+	assert.Equal(t, "...\n\t(stack truncated, increase MaxStackDepth)", lines[3])
+}
+
+// TestConcurrentReads reads the same error from many goroutines at once.  Errors are immutable
+// once created, so this is safe, and the race detector (go test -race) shouldn't report
+// anything.
+func TestConcurrentReads(t *testing.T) {
+	err := New("boom",
+		WithHTTPCode(404),
+		WithUserMessage("not found"),
+		WithKind("NotFound"),
+		WithCause(New("no rows")),
+		WithNamedStack("dispatched at", nil),
+	)
+	want := Details(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.Equal(t, want, Details(err))
+				assert.Equal(t, 404, HTTPCode(err))
+				assert.Equal(t, "not found", Value(err, errKeyUserMessage))
+				assert.NotEmpty(t, Stacktrace(err))
+				assert.NotEmpty(t, RegisteredDetails(err))
+				_ = fmt.Sprintf("%+v", err)
+			}
+		}()
+	}
+
+	// registering settings while errors are being read is safe too
+	RegisterKindHTTPCode("Concurrent", 409)
+	defer RegisterKindHTTPCode("Concurrent", 0)
+
+	wg.Wait()
+}
+
+func BenchmarkDetailsParallel(b *testing.B) {
+	err := New("boom", WithHTTPCode(404), WithUserMessage("not found"), WithCause(New("no rows")))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = Details(err)
+		}
+	})
+}
+
+func BenchmarkFormattedStack(b *testing.B) {
+	errs := make([]error, 1000)
+	for i := range errs {
+		errs[i] = New("boom")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		FormattedStack(errs[i%len(errs)])
+	}
+}
+
+func TestFrameCacheConcurrent(t *testing.T) {
+	err := New("boom")
+	want := Stacktrace(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, want, Stacktrace(New("boom", WithStack(Stack(err)))))
+		}()
+	}
+	wg.Wait()
+
+	for _, pc := range Stack(err) {
+		_, cached := frameCache.Load(pc)
+		assert.True(t, cached)
+	}
+}
+
+func TestStacktraceTruncated(t *testing.T) {
+	defer SetMaxStackDepth(MaxStackDepth())
+
+	// the test's stack is 3 frames deep: the test, testing.tRunner, and runtime.goexit
+	SetMaxStackDepth(2)
+	err := New("boom")
+	// the marker is only printed, it isn't part of the stack
+	assert.Len(t, Stack(err), 2)
+	assert.NotContains(t, Stack(err), truncatedFrames)
+	lines := FormattedStack(err)
+	require.Len(t, lines, 3)
+	assert.Equal(t, "...\n\t(stack truncated, increase MaxStackDepth)", lines[2])
+	assert.Contains(t, Details(err), "(stack truncated, increase MaxStackDepth)")
+
+	// the full stack fits, so it isn't marked
+	SetMaxStackDepth(100)
+	err = New("boom")
+	assert.NotContains(t, Stack(err), truncatedFrames)
+	assert.NotContains(t, Stacktrace(err), "stack truncated")
+}
+
+func TestDetailsTree(t *testing.T) {
+	assert.Empty(t, DetailsTree(nil))
+
+	rootCause := New("no rows", WithHTTPCode(404), NoCaptureStack())
+	err := New("load failed", WithValue("id", 5), WithCause(rootCause), NoCaptureStack())
+	err = &UnwrapperError{err}
+	err = Wrap(err, WithUserMessage("not found"), WithoutValue("id"), NoCaptureStack())
+
+	assert.Equal(t, `load failed
+  id: <removed>
+  user message: not found
+  *merry.UnwrapperError: load failed
+  stack: none
+  cause: no rows
+    stack: none
+    http status code: 404
+    root: no rows
+  id: 5
+  root: load failed`, DetailsTree(err))
+
+	// stacks are printed as their location
+	err = New("boom")
+	assert.Contains(t, DetailsTree(err), "stack: github.com/ansel1/merry/v2.TestDetailsTree (print_test.go:")
 }
@@ -0,0 +1,182 @@
+package merry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// stackTokenVersion is the first byte of a stack token, identifying its encoding.
+const stackTokenVersion = 2
+
+var buildFingerprintOnce sync.Once
+var buildFingerprint [8]byte
+
+// fingerprint returns a hash identifying the build of the running binary.  It is
+// computed from the binary's go build ID, which the go toolchain derives from the
+// contents of the binary, or from the binary itself if it has no build ID.  If the
+// binary can't be read, the fingerprint is random, so tokens can only be symbolized
+// by the process which created them.
+func fingerprint() [8]byte {
+	buildFingerprintOnce.Do(func() {
+		h := sha256.New()
+		if err := hashExecutable(h); err != nil {
+			_, _ = rand.Read(buildFingerprint[:])
+			return
+		}
+		copy(buildFingerprint[:], h.Sum(nil))
+	})
+	return buildFingerprint
+}
+
+// buildIDSearchSize is how much of the binary is searched for the build ID.  The go linker
+// places it in an ELF note, or at the start of the text segment, which are both near the
+// start of the binary.  The go command searches as much.
+const buildIDSearchSize = 32 * 1024
+
+// hashExecutable writes the build ID of the running binary to w, or if it has none, the
+// whole binary.
+func hashExecutable(w io.Writer) error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, buildIDSearchSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+
+	if id := findBuildID(head); id != nil {
+		_, err = w.Write(id)
+		return err
+	}
+	if _, err = w.Write(head); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+var buildIDPrefix = []byte("\xff Go build ID: \"")
+
+// buildIDNote is the type and name of the ELF note holding the build ID.  A note is laid
+// out as the length of its name, the length of its contents, its type, its name, and its
+// contents.
+var buildIDNote = []byte("\x04\x00\x00\x00Go\x00\x00")
+
+// findBuildID returns the go build ID embedded in data, or nil if there is none.
+func findBuildID(data []byte) []byte {
+	if i := bytes.Index(data, buildIDPrefix); i >= 0 {
+		id := data[i+len(buildIDPrefix):]
+		if end := bytes.IndexByte(id, '"'); end > 0 {
+			return id[:end]
+		}
+	}
+	if i := bytes.Index(data, buildIDNote); i >= 8 && binary.LittleEndian.Uint32(data[i-8:]) == 4 {
+		size := int(binary.LittleEndian.Uint32(data[i-4:]))
+		id := data[i+len(buildIDNote):]
+		if size > 0 && size <= len(id) {
+			return id[:size]
+		}
+	}
+	return nil
+}
+
+// stackBase is the program counter which the program counters in stack tokens are
+// relative to, so tokens don't depend on where the binary was loaded in memory.
+func stackBase() int64 {
+	return int64(reflect.ValueOf(StackToken).Pointer())
+}
+
+// StackToken encodes the stack attached to err into a compact, single line token, for
+// structured logs.  The token can be expanded into a stacktrace with SymbolizeToken,
+// by the same build of the same binary, e.g. with a debug endpoint or command.  The
+// token identifies the build which created it, so it can't be symbolized by another build.
+//
+// Returns "" if err has no stack.
+func StackToken(err error) string {
+	s := markedStack(err)
+	if len(s) == 0 {
+		return ""
+	}
+
+	fp := fingerprint()
+	buf := make([]byte, 0, 1+len(fp)+len(s)*binary.MaxVarintLen32)
+	buf = append(buf, stackTokenVersion)
+	buf = append(buf, fp[:]...)
+
+	var tmp [binary.MaxVarintLen64]byte
+	base := stackBase()
+	for _, pc := range s {
+		switch pc {
+		case elidedFrames, truncatedFrames:
+			// an offset of 0 is never the return address of a call, so it
+			// escapes the markers, which are encoded as is.
+			buf = append(buf, tmp[:binary.PutVarint(tmp[:], 0)]...)
+			buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(pc))]...)
+		default:
+			buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(pc)-base)]...)
+		}
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// SymbolizeToken expands a token created by StackToken into a stacktrace, formatted
+// like Stacktrace().  Returns an error if the token is invalid, or was created by a
+// different build of the binary.
+func SymbolizeToken(token string) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.New("merry: invalid stack token: " + err.Error())
+	}
+
+	fp := fingerprint()
+	if len(buf) < 1+len(fp) || buf[0] != stackTokenVersion {
+		return "", errors.New("merry: invalid stack token")
+	}
+	if string(buf[1:1+len(fp)]) != string(fp[:]) {
+		return "", errors.New("merry: stack token was created by a different build")
+	}
+	buf = buf[1+len(fp):]
+
+	base := stackBase()
+	var s []uintptr
+	for len(buf) > 0 {
+		offset, n := binary.Varint(buf)
+		if n <= 0 {
+			return "", errors.New("merry: invalid stack token")
+		}
+		buf = buf[n:]
+
+		if offset != 0 {
+			s = append(s, uintptr(base+offset))
+			continue
+		}
+
+		marker, n := binary.Uvarint(buf)
+		if n <= 0 || (uintptr(marker) != elidedFrames && uintptr(marker) != truncatedFrames) {
+			return "", errors.New("merry: invalid stack token")
+		}
+		buf = buf[n:]
+		s = append(s, uintptr(marker))
+	}
+
+	return strings.Join(formatStack(s), "\n"), nil
+}
@@ -0,0 +1,65 @@
+package merry
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStackToken(t *testing.T) {
+	assert.Empty(t, StackToken(nil))
+	assert.Empty(t, StackToken(New("boom", NoCaptureStack())))
+
+	err := New("boom")
+	token := StackToken(err)
+	require.NotEmpty(t, token)
+	assert.NotContains(t, token, "\n")
+
+	s, e := SymbolizeToken(token)
+	require.NoError(t, e)
+	assert.Equal(t, Stacktrace(err), s)
+
+	// markers survive the round trip
+	err = New("boom", CaptureStackHeadTail(1, 1))
+	s, e = SymbolizeToken(StackToken(err))
+	require.NoError(t, e)
+	assert.Equal(t, Stacktrace(err), s)
+
+	// invalid tokens
+	_, e = SymbolizeToken("not a token!")
+	assert.Error(t, e)
+	_, e = SymbolizeToken("")
+	assert.Error(t, e)
+
+	// tokens from another build
+	buf, _ := base64.RawURLEncoding.DecodeString(token)
+	buf[1]++
+	_, e = SymbolizeToken(base64.RawURLEncoding.EncodeToString(buf))
+	assert.EqualError(t, e, "merry: stack token was created by a different build")
+}
+
+func TestFindBuildID(t *testing.T) {
+	assert.Equal(t, []byte("abc/def"), findBuildID([]byte("\x00\xff Go build ID: \"abc/def\"\n \xff")))
+	assert.Nil(t, findBuildID([]byte("no build id")))
+	assert.Nil(t, findBuildID([]byte("\xff Go build ID: \"\"\n \xff")))
+	assert.Nil(t, findBuildID([]byte("\xff Go build ID: \"truncated")))
+
+	// ELF note
+	assert.Equal(t, []byte("abc/def"), findBuildID([]byte("\x00\x04\x00\x00\x00\x07\x00\x00\x00\x04\x00\x00\x00Go\x00\x00abc/def\x00")))
+	assert.Nil(t, findBuildID([]byte("\x04\x00\x00\x00\x70\x00\x00\x00\x04\x00\x00\x00Go\x00\x00abc/def\x00")))
+
+	// the test binary has a build ID, near its start
+	path, err := os.Executable()
+	require.NoError(t, err)
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	head := make([]byte, buildIDSearchSize)
+	n, _ := io.ReadFull(f, head)
+	assert.NotEmpty(t, findBuildID(head[:n]))
+
+	assert.NotEqual(t, [8]byte{}, fingerprint())
+}
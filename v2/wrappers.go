@@ -1,6 +1,17 @@
 package merry
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
 
 // Wrapper knows how to wrap errors with context information.
 type Wrapper interface {
@@ -34,11 +45,138 @@ func WithValue(key, value interface{}) Wrapper {
 	})
 }
 
+// WithoutValue masks the value attached to an error with key, so Value(), Lookup(),
+// Values(), Fields(), and Details() behave as if it had never been attached.  Since errors
+// are immutable, the value isn't removed from the wrapped error, only hidden by a new layer.
+// It is intended for redacting values before passing an error on:
+//
+//	err = merry.Wrap(err, merry.WithoutValue("request body"))
+//
+// A value attached with key by a later wrapper is visible again.
+func WithoutValue(key interface{}) Wrapper {
+	return WithValue(key, removedValue{})
+}
+
+// WithGroup namespaces the values attached with string keys by the wrappers which
+// follow it in the same call to Wrap or Apply.  Those values are nested under the group
+// name in the output of Fields(), like slog's groups:
+//
+//	err = merry.Wrap(err, merry.WithGroup("db"), merry.WithValue("query", q), merry.WithValue("rows", 3))
+//	merry.Fields(err) // map[db:map[query:... rows:3]]
+//
+// Groups may be nested.  Values attached with non-string keys, like the HTTP code or user
+// message, are unaffected.  Grouped values can no longer be retrieved by their bare key
+// with Value().
+func WithGroup(name string) Wrapper {
+	return &groupWrapper{name: name}
+}
+
+type groupWrapper struct {
+	name string
+}
+
+// Wrap implements the Wrapper interface.  On its own, a group has no effect.  ApplySkipping
+// handles groups specially, by applying the wrappers which follow the group with apply().
+func (g *groupWrapper) Wrap(err error, _ int) error {
+	return err
+}
+
+// apply applies the wrappers to err, then re-keys the values they attached
+// under this group.
+func (g *groupWrapper) apply(err error, skip int, wrappers []Wrapper) error {
+	wrapped := ApplySkipping(err, skip+1, wrappers...)
+
+	// collect the layers added by the wrappers.  If the wrappers added layers
+	// which can't be rebuilt, or replaced err entirely, leave the result alone.
+	var layers []error
+	for layer := wrapped; !sameError(layer, err); layer = unwrapLayer(layer) {
+		switch layer.(type) {
+		case *errWithValue, *errWithCause:
+			layers = append(layers, layer)
+		default:
+			return wrapped
+		}
+	}
+
+	// rebuild the layers, from the inside out
+	for i := len(layers) - 1; i >= 0; i-- {
+		switch t := layers[i].(type) {
+		case *errWithValue:
+			key := t.key
+			switch key.(type) {
+			case string, groupKey:
+				key = groupKey{group: g.name, key: key}
+			}
+			err = Set(err, key, t.value)
+		case *errWithCause:
+			err = &errWithCause{err: err, cause: t.cause}
+		}
+	}
+
+	return err
+}
+
+// sameError compares errors, without panicking on errors which aren't comparable.
+func sameError(err1, err2 error) bool {
+	if err1 == nil || err2 == nil {
+		return err1 == err2
+	}
+	return reflect.TypeOf(err1).Comparable() && err1 == err2
+}
+
+// ValueKey is a typed key for attaching values to errors.  It binds the key to the type
+// of its value, so values can be attached and read back without type assertions, and
+// without the risk of attaching a value of the wrong type.  Packages typically declare
+// keys as package variables:
+//
+//	var UserIDKey = merry.NewValueKey[string]("userID")
+//
+//	err = merry.Wrap(err, UserIDKey.With("u123"))
+//	id, ok := UserIDKey.From(err)
+//
+// Each key created with NewValueKey is distinct, even if the names are the same.
+type ValueKey[T any] struct {
+	name string
+}
+
+// NewValueKey creates a new ValueKey.  name is used when printing the key, e.g. in Details().
+func NewValueKey[T any](name string) *ValueKey[T] {
+	return &ValueKey[T]{name: name}
+}
+
+// With returns a Wrapper which attaches v to an error with this key.
+func (k *ValueKey[T]) With(v T) Wrapper {
+	return WithValue(k, v)
+}
+
+// From returns the value attached to err with this key.  Returns the zero value of T and
+// false if not set.  Will not search causes.
+func (k *ValueKey[T]) From(err error) (T, bool) {
+	return ValueAs[T](err, k)
+}
+
+// String implements fmt.Stringer.  It returns the key's name.
+func (k *ValueKey[T]) String() string {
+	return k.name
+}
+
 // WithMessage overrides the value returned by err.Error().
 func WithMessage(msg string) Wrapper {
 	return WithValue(errKeyMessage, msg)
 }
 
+// SetMessageKeepingOld is like WithMessage, but keeps the message it overrides, so it isn't
+// lost when error messages are normalized, e.g. at a boundary.  Details() prints it as
+// "Previous Message: ...".  See PreviousMessage.
+func SetMessageKeepingOld(msg string) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		return Set(Set(err, errKeyPreviousMessage, err.Error()), errKeyMessage, msg)
+	})
+}
+
 // WithMessagef overrides the value returned by err.Error().
 func WithMessagef(format string, args ...interface{}) Wrapper {
 	return WrapperFunc(func(err error, _ int) error {
@@ -49,7 +187,9 @@ func WithMessagef(format string, args ...interface{}) Wrapper {
 	})
 }
 
-// WithUserMessage associates an end-user message with an error.
+// WithUserMessage associates an end-user message with an error.  If the error's
+// message is empty, the user message is used as its message too, so Error() never
+// returns an empty message when a user message is available.
 func WithUserMessage(msg string) Wrapper {
 	return WithValue(errKeyUserMessage, msg)
 }
@@ -64,6 +204,19 @@ func WithUserMessagef(format string, args ...interface{}) Wrapper {
 	})
 }
 
+// WithUserMessageKey associates a message key with an error, which is translated into a
+// localized end-user message by LocalizedUserMessage.  See SetUserMessageLocalizer.
+func WithUserMessageKey(key string) Wrapper {
+	return WithValue(errKeyUserMessageKey, key)
+}
+
+// WithLocale associates a locale with an error, as a BCP 47 language tag, e.g. "en-US".
+// This is the locale the error's user message is written in, typically the locale of
+// the request which caused the error.
+func WithLocale(tag string) Wrapper {
+	return WithValue(errKeyLocale, tag)
+}
+
 // AppendMessage a message after the current error message, in the format "original: new".
 func AppendMessage(msg string) Wrapper {
 	return WrapperFunc(func(err error, _ int) error {
@@ -104,11 +257,138 @@ func PrependMessagef(format string, args ...interface{}) Wrapper {
 	})
 }
 
+// AppendMessageUnique is like AppendMessage, but is a no-op if the current error message
+// already ends with the message.  This avoids repeating the same message when an error passes
+// through the same code more than once, e.g. when retrying.
+func AppendMessageUnique(msg string) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		if strings.HasSuffix(err.Error(), ": "+msg) {
+			return err
+		}
+		return Set(err, errKeyMessage, err.Error()+": "+msg)
+	})
+}
+
+// PrependMessageUnique is like PrependMessage, but is a no-op if the current error message
+// already starts with the message.  This avoids repeating the same message when an error passes
+// through the same code more than once, e.g. when retrying.
+func PrependMessageUnique(msg string) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		if strings.HasPrefix(err.Error(), msg+": ") {
+			return err
+		}
+		return Set(err, errKeyMessage, msg+": "+err.Error())
+	})
+}
+
 // WithHTTPCode associates an HTTP status code with an error.
 func WithHTTPCode(statusCode int) Wrapper {
 	return WithValue(errKeyHTTPCode, statusCode)
 }
 
+// WithHTTPHeaders associates HTTP response headers with an error, e.g. a WWW-Authenticate
+// header for a 401 error.  The headers are merged with any headers already attached to
+// the error: values in h replace the existing values for the same header.  See HTTPHeaders.
+//
+// h is copied, so it is safe to modify h afterward.
+func WithHTTPHeaders(h http.Header) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		merged := HTTPHeaders(err)
+		if merged == nil {
+			merged = http.Header{}
+		}
+		for k, v := range h.Clone() {
+			merged[k] = v
+		}
+		return Set(err, errKeyHTTPHeaders, merged)
+	})
+}
+
+// WithKind associates a kind with an error, e.g. "NotFound", "Conflict", or "Validation".
+// Kinds categorize errors independently of transport codes, like HTTP status codes or
+// gRPC codes.  See KindOf and IsKind.
+func WithKind(kind string) Wrapper {
+	return WithValue(errKeyKind, kind)
+}
+
+// WithOp associates the name of a logical operation with an error, e.g. "GetUser".  As
+// the error is returned up the call stack, each layer can add its own operation.  See Op
+// and Ops.
+func WithOp(op string) Wrapper {
+	return WithValue(errKeyOp, op)
+}
+
+// WithRetryAfter associates a retry delay with an error, indicating how long the client
+// should wait before retrying the request, typically for rate limited or unavailable
+// responses.  See RetryAfter.
+func WithRetryAfter(d time.Duration) Wrapper {
+	return WithValue(errKeyRetryAfter, d)
+}
+
+// WithHint associates a hint with an error, telling the end user what to do about it,
+// e.g. "run `myapp login`" for an authentication error.  The user message says what went
+// wrong, and the hint says how to fix it.  Like the user message, the hint should be safe
+// to show to end users.  Details() prints it as "Hint: ...".  See Hint.
+func WithHint(hint string) Wrapper {
+	return WithValue(errKeyHint, hint)
+}
+
+// Downgrade marks the error as an expected, benign error, which should be logged, but
+// shouldn't fail the operation, e.g. a known harmless error from a library.  It sets the
+// error's severity to "warning", and IsFatal() returns false for it.  Details() prints the
+// severity as "Severity: warning".  It doesn't change the error's HTTP code: a downgraded
+// error which is returned to a client is still reported as a failure.
+//
+//	if err := cache.Set(key, value); err != nil {
+//		return merry.Wrap(err, merry.Downgrade())
+//	}
+func Downgrade() Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		return Set(err, errKeySeverity, "warning")
+	})
+}
+
+// MarkLogged marks the error as logged, so layers which each log the errors passing through
+// them can avoid logging the same error twice.  See WasLogged.  Errors are immutable, so
+// this returns a new error, which the caller must propagate in place of the original:
+//
+//	if !merry.WasLogged(err) {
+//		log.Print(merry.Details(err))
+//		err = merry.Wrap(err, merry.MarkLogged())
+//	}
+//	return err
+func MarkLogged() Wrapper {
+	return WithValue(errKeyLogged, true)
+}
+
+// WithElapsedSince attaches the time elapsed since start, e.g. the start of the operation
+// which failed.  Details() prints it as "Elapsed: 1.2s".  See Elapsed.
+//
+//	start := time.Now()
+//	if err := doWork(); err != nil {
+//		return merry.Wrap(err, merry.WithElapsedSince(start))
+//	}
+func WithElapsedSince(start time.Time) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		return Set(err, errKeyElapsed, time.Since(start))
+	})
+}
+
 // WithStack associates a stack of caller frames with an error.  Generally, this package
 // will automatically capture and associate a stack with errors which are created or
 // wrapped by this package.  But this allows the caller to associate an externally
@@ -117,25 +397,69 @@ func WithStack(stack []uintptr) Wrapper {
 	return WithValue(errKeyStack, stack)
 }
 
+// WithNamedStack associates a stack with an error, under a label, e.g. "dispatched at".
+// Named stacks are useful for attributing asynchronous flows, which may pass through
+// several interesting call sites.  They are kept in addition to the error's stack, which
+// is unaffected.  Each label holds one stack: attaching a stack with the same label replaces it.
+// Named stacks are returned by NamedStacks() and Stacks(), and printed by Details() under
+// their labels.
+//
+// Stacks can be captured with runtime.Callers.
+func WithNamedStack(label string, stack []uintptr) Wrapper {
+	return WithValue(namedStackKey{label: label}, stack)
+}
+
+// WithStackIfAbsent is like WithStack, but is a no-op if the error already has a stack
+// attached (see HasStack).  It is useful when importing stacks from external sources,
+// where a stack previously captured by this package should take precedence.
+func WithStackIfAbsent(stack []uintptr) Wrapper {
+	return withStackIfAbsent(stack)
+}
+
+// withStackIfAbsent implements WithStackIfAbsent.  stack is either a []uintptr or
+// a *capturedStack.
+func withStackIfAbsent(stack interface{}) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if HasStack(err) {
+			return err
+		}
+		return Set(err, errKeyStack, stack)
+	})
+}
+
 // WithFormattedStack associates a stack of pre-formatted strings describing frames of a
 // stacktrace.  Generally, a formatted stack is generated from the raw []uintptr stack
 // associated with the error, but a pre-formatted stack can be associated with the error
 // instead, and takes precedence over the raw stack.  This is useful if pre-formatted
-// stack information is coming from some other source.
+// stack information is coming from some other source.  See ParseFormattedStack for
+// reattaching a stack printed by Stacktrace().
 func WithFormattedStack(stack []string) Wrapper {
 	return WithValue(errKeyStack, stack)
 }
 
 // NoCaptureStack will suppress capturing a stack, even if StackCaptureEnabled() == true.
+// Unlike SetStackCaptureEnabled, this only affects the errors it is applied to, so it is
+// the recommended way for hot code paths to create errors without the cost of capturing
+// stacks.  The returned Wrapper is shared, so calling this doesn't allocate.
 func NoCaptureStack() Wrapper {
-	return WrapperFunc(func(err error, _ int) error {
-		// if this err already has a stack set, there is no need to set the
-		// stack property again, and we don't want to override the prior the stack
-		if HasStack(err) {
-			return err
-		}
-		return Set(err, errKeyStack, nil)
-	})
+	return noCaptureStack
+}
+
+var noCaptureStack = WrapperFunc(func(err error, _ int) error {
+	// if this err already has a stack set, there is no need to set the
+	// stack property again, and we don't want to override the prior the stack
+	if HasStack(err) {
+		return err
+	}
+	return Set(err, errKeyStack, nil)
+})
+
+// SuppressDetailsStack excludes the error's stacks from the output of Details() and
+// %+v, to keep logs clean for expected errors, like "not found" errors.  Unlike
+// NoCaptureStack, the stacks are still captured, and can be read with Stack(),
+// FormattedStack(), etc.  It doesn't affect the errors attached as causes.
+func SuppressDetailsStack() Wrapper {
+	return WithValue(errKeyHideStack, true)
 }
 
 // CaptureStack will override an earlier stack with a stack captured from the current
@@ -148,6 +472,368 @@ func CaptureStack(force bool) Wrapper {
 	})
 }
 
+// Breadcrumb records a note, along with the location of the call site, without changing
+// the error's message.  Each call adds another entry to the error's trail of breadcrumbs,
+// which can be used to trace the path an error took as it was returned up the call stack.
+// See Breadcrumbs.
+func Breadcrumb(note string) Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil {
+			return nil
+		}
+		b := breadcrumb{note: note}
+		if pc, file, line, ok := runtime.Caller(callerDepth + 1); ok {
+			_, b.file = path.Split(file)
+			b.line = line
+			if fn := runtime.FuncForPC(pc); fn != nil {
+				b.function = fn.Name()
+			}
+		}
+		return Set(err, errKeyBreadcrumb, b)
+	})
+}
+
+type breadcrumb struct {
+	note, function, file string
+	line                 int
+}
+
+func (b breadcrumb) String() string {
+	switch {
+	case b.file == "":
+		return b.note
+	case b.line == 0:
+		return fmt.Sprintf("%s: %s (%s)", b.note, b.function, b.file)
+	default:
+		return fmt.Sprintf("%s: %s (%s:%d)", b.note, b.function, b.file, b.line)
+	}
+}
+
+// QuickStackDepth is the number of frames captured by QuickStack.
+const QuickStackDepth = 8
+
+// QuickStack captures a short stack of QuickStackDepth frames, regardless of MaxStackDepth().
+// Capturing stacks is the most expensive part of creating an error, and the cost grows with
+// the number of frames captured.  This is useful for errors whose stacks are only used to show
+// where they were created, or for code paths which create many errors.
+//
+// Like the automatic stack capture, it is a no-op if the error already has a stack, or if
+// StackCaptureEnabled() == false.
+func QuickStack() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil || HasStack(err) || !StackCaptureEnabled() {
+			return err
+		}
+		var s [QuickStackDepth]uintptr
+		length := runtime.Callers(2+callerDepth, s[:])
+		return Set(err, errKeyStack, s[:length:length])
+	})
+}
+
+// WithMaxStackDepth overrides MaxStackDepth() for the error, so specific call sites can
+// capture deeper, or shallower, stacks than the global setting.  It is applied before the
+// stack is captured, so it affects the automatic stack capture, and CaptureStack:
+//
+//	err := merry.New("recursion failed", merry.WithMaxStackDepth(200))
+//
+// It has no effect on errors which already have a stack, unless the stack is captured
+// again with CaptureStack(true).
+func WithMaxStackDepth(depth int) Wrapper {
+	return WithValue(errKeyMaxDepth, depth)
+}
+
+// LocationOnly captures a single frame, the call site, instead of a full stack.  Location()
+// and SourceLine() work as usual, but Stacktrace() only prints that frame.  It is a middle
+// ground between NoCaptureStack and capturing a full stack, for high volume errors where
+// only the origin of the error matters.
+//
+// Like the automatic stack capture, it is a no-op if the error already has a stack, or if
+// StackCaptureEnabled() == false.
+func LocationOnly() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil || HasStack(err) || !StackCaptureEnabled() {
+			return err
+		}
+		s := callersDepth(callerDepth+1, 1)
+		if len(s) > 1 {
+			// drop the truncation marker
+			s = s[:1:1]
+		}
+		return Set(err, errKeyStack, s)
+	})
+}
+
+// WithCaller attaches the caller's function name, file, and line to the error.  Details()
+// prints it as "Caller: pkg.Func (file.go:12)".  It is a single frame, which is much cheaper
+// to capture than a stack, and is only resolved when it is printed.  Unlike LocationOnly, it
+// is captured even if StackCaptureEnabled() == false, and doesn't affect the error's stack,
+// so it gives high volume paths which don't capture stacks a point of attribution.
+// See Caller.
+func WithCaller() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil {
+			return nil
+		}
+		var s [1]uintptr
+		if runtime.Callers(2+callerDepth, s[:]) == 0 {
+			return err
+		}
+		return Set(err, errKeyCaller, callerPC(s[0]))
+	})
+}
+
+// WithSourceInMessage appends the caller's file and line to the error's message, so Error()
+// returns "message (file.go:12)".  It is a lightweight tag pointing at where the error was
+// created or wrapped, for quick debugging, e.g. in development environments, without the
+// full dump printed by Details().  Like WithCaller, it is captured even if
+// StackCaptureEnabled() == false.
+func WithSourceInMessage() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil {
+			return nil
+		}
+		var s [1]uintptr
+		if runtime.Callers(2+callerDepth, s[:]) == 0 {
+			return err
+		}
+		return Set(err, errKeySourceInMessage, callerPC(s[0]))
+	})
+}
+
+// CaptureStackFrom sets the error's stack to a single frame, identified by a program counter
+// captured earlier, e.g. with runtime.Caller, replacing any existing stack.  Location() and
+// SourceLine() report that frame.  This helps frameworks which invoke user code attribute
+// errors to a meaningful call site, like where a handler was registered, instead of
+// wherever the error surfaced:
+//
+//	func (r *Router) Handle(path string, h Handler) {
+//		pc, _, _, _ := runtime.Caller(1)
+//		r.routes[path] = func(req *Request) error {
+//			return merry.Wrap(h(req), merry.CaptureStackFrom(pc))
+//		}
+//	}
+//
+// If pc is 0, this is a no-op.
+func CaptureStackFrom(pc uintptr) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil || pc == 0 {
+			return err
+		}
+		return Set(err, errKeyStack, []uintptr{pc})
+	})
+}
+
+// CaptureStackHeadTail is like CaptureStack(false), but the captured stack only keeps the
+// newest head frames and the oldest tail frames, regardless of MaxStackDepth().  The frames
+// in between are replaced with a marker, which Stacktrace() prints as "...".  This bounds the size
+// of very deep stacks, e.g. from recursion, while keeping the frames where the error occurred
+// and the frames at the base of the goroutine.  head must be at least 1.
+//
+// If StackCaptureEnabled() == false, this is a no-op.
+func CaptureStackHeadTail(head, tail int) Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil || !StackCaptureEnabled() {
+			return err
+		}
+		return Set(err, errKeyStack, capturedValue(captureHeadTail(callerDepth+1, head, tail)))
+	})
+}
+
+// AddStack captures an additional stack, marking where the error crossed an architectural
+// boundary, like a service or API layer.  Unlike CaptureStack(true), the stack captured
+// where the error originated is kept: the boundary stack is attached separately.  Each
+// call adds another boundary stack.  Boundary stacks are returned by Stacks(), and printed
+// by Details() after the error's own stacktrace.
+//
+// If StackCaptureEnabled() == false, this is a no-op.
+func AddStack() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil || !StackCaptureEnabled() {
+			return err
+		}
+		return Set(err, errKeyBoundaryStack, capturedValue(callers(callerDepth+1)))
+	})
+}
+
+// RecordLayer is meant to be installed as a hook.  It records the architectural layer which
+// created the error, as registered with RegisterLayer, by finding the newest frame in the
+// error's stack which belongs to a registered layer.  If the error doesn't have a stack yet,
+// the frames of the call site are used.  The layer is printed by Details() under the
+// label "Layer", and can be read with Layer().
+//
+// Once a layer is recorded, it is not changed, so the layer is where the error was created, not
+// where it was last wrapped.  Install it as a once hook to avoid searching the stack each time
+// an error is wrapped:
+//
+//	merry.AddOnceHooks(merry.RecordLayer())
+func RecordLayer() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if err == nil {
+			return nil
+		}
+		if _, ok := Lookup(err, errKeyLayer); ok {
+			return err
+		}
+		s := markedStack(err)
+		if len(s) == 0 {
+			s = make([]uintptr, MaxStackDepth())
+			s = s[:runtime.Callers(2+callerDepth, s)]
+		}
+		if layer := layerOf(s); layer != "" {
+			return Set(err, errKeyLayer, layer)
+		}
+		return err
+	})
+}
+
+// AutoHTTPCode is meant to be installed as a hook.  If the error doesn't have an HTTP code
+// attached, and it matches one of the errors registered with RegisterHTTPCode, like
+// context.DeadlineExceeded or sql.ErrNoRows, it gets the registered code:
+//
+//	merry.AddHooks(merry.AutoHTTPCode())
+//
+// The code is looked up when it is read, e.g. by HTTPCode(), rather than when the hook
+// runs, so causes attached by the wrappers passed to the same call, which are applied
+// after the hooks, are matched too.  An HTTP code attached with WithHTTPCode always
+// takes precedence.
+func AutoHTTPCode() Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil || HasValue(err, errKeyHTTPCode) {
+			return err
+		}
+		return Set(err, errKeyHTTPCode, autoHTTPCode{})
+	})
+}
+
+// WithBuildInfo is meant to be installed as a hook.  It attaches the version of the main
+// module of the binary, as recorded by the go toolchain, so errors logged by several deployed
+// versions can be told apart.  Binaries built from a source checkout, rather than a tagged
+// module version, have the VCS revision instead, with a "-dirty" suffix if there were
+// uncommitted changes.  The version is printed by Details() under the label "Build", and
+// can be read with Build():
+//
+//	merry.AddOnceHooks(merry.WithBuildInfo())
+//
+// If the binary has no build information, it is a no-op.
+func WithBuildInfo() Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil || HasValue(err, errKeyBuild) {
+			return err
+		}
+		if version := buildVersion(); version != "" {
+			return Set(err, errKeyBuild, version)
+		}
+		return err
+	})
+}
+
+var buildVersionOnce sync.Once
+var buildVersionValue string
+
+// buildVersion returns the version of the running binary's main module.
+func buildVersion() string {
+	buildVersionOnce.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			buildVersionValue = versionOf(info)
+		}
+	})
+	return buildVersionValue
+}
+
+// versionOf returns the version of the main module, or its VCS revision if it wasn't
+// built from a tagged version.
+func versionOf(info *debug.BuildInfo) string {
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision == "" {
+		return ""
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// Tap calls fn with the error, and returns the error unchanged.  It is useful for side
+// effects at a specific point, like logging or counting an error where it crosses a
+// known boundary, without installing a global hook:
+//
+//	return merry.Wrap(err, merry.Tap(func(err error) { errCount.Inc() }))
+//
+// fn sees the error as wrapped by the Wrappers which precede Tap in the
+// same call, but not those which follow it.  If the error is nil, fn is not called.
+func Tap(fn func(err error)) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err != nil && fn != nil {
+			fn(err)
+		}
+		return err
+	})
+}
+
+// AlwaysVerbose makes the error's Error() method return Details(), rather than just the
+// error's message.  It is meant for errors handed to logging sinks which only print
+// errors with %v or Error(), but should log the details:
+//
+//	logger.Print(merry.Wrap(err, merry.AlwaysVerbose()))
+//
+// Only the error it is applied to is affected, unlike the global verbose setting of
+// merry v1.  It should be applied last: Wrappers applied to the error afterward delegate
+// to the verbose Error(), so their own details, like values and stacks, are not printed
+// by it.  Where possible, prefer VerboseError(err).
+//
+// Since the details are printed from the error it wraps, it captures the error's stack
+// first, if the error doesn't have one, like Wrap would.
+func AlwaysVerbose() Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		err = captureStack(err, callerDepth+1, false)
+		return Set(err, errKeyVerbose, true)
+	})
+}
+
+// WithSuppressed attaches errors which were suppressed while handling the error, like
+// Java's suppressed exceptions.  Typically, these are errors from cleaning up after the
+// error, which shouldn't replace it:
+//
+//	if cerr := f.Close(); cerr != nil {
+//		err = merry.Wrap(err, merry.WithSuppressed(cerr))
+//	}
+//
+// The errors are added to any errors already suppressed by the error.  Nil errors are
+// ignored.  Unlike causes, suppressed errors are not matched by errors.Is() or errors.As():
+// they are purely informational, and are printed by Details().  See Suppressed.
+func WithSuppressed(errs ...error) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil {
+			return nil
+		}
+		suppressed := Suppressed(err)
+		for _, e := range errs {
+			if e != nil {
+				suppressed = append(suppressed, e)
+			}
+		}
+		if len(suppressed) == 0 {
+			return err
+		}
+		return Set(err, errKeySuppressed, suppressed)
+	})
+}
+
 // WithCause sets one error as the cause of another error.  This is useful for associating errors
 // from lower API levels with sentinel errors in higher API levels.  errors.Is() and errors.As()
 // will traverse both the main chain of error wrappers, and down the chain of causes.
@@ -158,10 +844,96 @@ func WithCause(err error) Wrapper {
 		if nerr == nil || err == nil {
 			return nerr
 		}
-		return &errWithCause{err: nerr, cause: err}
+		cause := err
+		if depth := MaxCauseDepth(); depth > 0 {
+			cause = limitCauses(cause, depth-1)
+		}
+		return &errWithCause{err: nerr, cause: cause}
+	})
+}
+
+// Classify tags the error as belonging to the category represented by a sentinel error, so
+// errors.Is() matches the error against the sentinel:
+//
+//	var ErrNotFound = merry.Sentinel("not found", merry.WithHTTPCode(404))
+//
+//	if errors.Is(err, sql.ErrNoRows) {
+//		err = merry.Wrap(err, merry.Classify(ErrNotFound))
+//	}
+//	errors.Is(err, ErrNotFound) // true
+//
+// Unlike WithCause, the sentinel is only used for matching: it doesn't replace the error's
+// cause, it isn't returned by Cause(), and neither its message nor its values, like its HTTP
+// code, are added to the error.  An error can be classified with several sentinels.
+//
+// If sentinel is nil, this is a no-op.
+func Classify(sentinel error) Wrapper {
+	return WrapperFunc(func(err error, _ int) error {
+		if err == nil || sentinel == nil {
+			return err
+		}
+		return Set(err, errKeyClass, sentinel)
+	})
+}
+
+// withCauseIfAbsent sets cause as the cause of the error, unless the error already has a
+// cause.  Then it classifies the error with cause instead, so errors.Is() matches cause
+// without replacing the error's own cause.
+func withCauseIfAbsent(cause error) Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		if Cause(err) == nil {
+			return WithCause(cause).Wrap(err, callerDepth+1)
+		}
+		return Classify(cause).Wrap(err, callerDepth+1)
+	})
+}
+
+// Because sets cause as the cause of the error, and prepends msg to the error's message.
+// It captures the common idiom of a high-level message, with a low-level cause:
+//
+//	return merry.Wrap(ErrLoadUser, merry.Because(dbErr, "user "+id))
+//
+// If cause is nil, only the message is prepended.
+func Because(cause error, msg string) Wrapper {
+	return WrapperFunc(func(err error, callerDepth int) error {
+		return ApplySkipping(err, callerDepth+1, PrependMessage(msg), WithCause(cause))
+	})
+}
+
+// ImportChain attaches an error from another error package as the cause, importing each
+// error in its chain of wrapped errors as a nested cause.  Details() will print each of
+// them, along with any details attached to them.  errors.Is() and errors.As() will
+// traverse all of them, as usual.  This gives better visibility into errors from packages
+// which wrap errors with rich context than attaching the error with WithCause.
+//
+// Importing stops at the first error in the chain created by this package, which is
+// attached as-is.
+//
+// If err is nil, this is a no-op
+func ImportChain(err error) Wrapper {
+	return WrapperFunc(func(nerr error, _ int) error {
+		if nerr == nil || err == nil {
+			return nerr
+		}
+		return &errWithCause{err: nerr, cause: importChain(err)}
 	})
 }
 
+// importChain imports each error in err's chain as the cause of the error wrapping it.
+// The chain is linked through the causes, so each error is wrapped with an importedError,
+// which hides the rest of the chain from errors.Is() and errors.As(), and Details().
+func importChain(err error) error {
+	if _, ok := err.(interface{ isMerryError() }); ok {
+		return err
+	}
+
+	next := errors.Unwrap(err)
+	if next == nil {
+		return err
+	}
+	return &errWithCause{err: &importedError{err: err}, cause: importChain(next)}
+}
+
 // Set wraps an error with a key/value pair.  This is the simplest form of associating
 // a value with an error.  It does not capture a stacktrace, invoke hooks, or do any
 // other processing.  It is mainly intended as a primitive for writing Wrapper implementations.
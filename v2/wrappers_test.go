@@ -1,10 +1,21 @@
 package merry
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"path"
 	"runtime"
+	"runtime/debug"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWrappers(t *testing.T) {
@@ -48,6 +59,128 @@ func TestWrappers(t *testing.T) {
 				assert.Equal(t, "big boom", UserMessage(err))
 			},
 		},
+		{
+			name:    "WithUserMessageKey",
+			wrapper: WithUserMessageKey("greeting"),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, "greeting", Value(err, errKeyUserMessageKey))
+			},
+		},
+		{
+			name:    "WithLocale",
+			wrapper: WithLocale("fr-FR"),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, "fr-FR", Locale(err))
+			},
+		},
+		{
+			name:    "WithHTTPHeaders",
+			wrapper: WithHTTPHeaders(http.Header{"Retry-After": {"5"}}),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, "5", HTTPHeaders(err).Get("Retry-After"))
+			},
+		},
+		{
+			name:    "WithKind",
+			wrapper: WithKind("NotFound"),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, "NotFound", KindOf(err))
+			},
+		},
+		{
+			name:    "WithOp",
+			wrapper: WithOp("GetUser"),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, "GetUser", Op(err))
+			},
+		},
+		{
+			name:    "WithRetryAfter",
+			wrapper: WithRetryAfter(time.Minute),
+			assertions: func(t *testing.T, err error) {
+				d, _ := RetryAfter(err)
+				assert.Equal(t, time.Minute, d)
+			},
+		},
+		{
+			name:    "SetMessageKeepingOld",
+			wrapper: SetMessageKeepingOld("not found"),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "not found")
+				assert.Equal(t, "bang", PreviousMessage(err))
+				assert.Contains(t, Details(err), "Previous Message: bang")
+			},
+		},
+		{
+			name:    "Classify",
+			wrapper: Classify(io.EOF),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "bang")
+				assert.ErrorIs(t, err, io.EOF)
+				assert.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+				assert.Nil(t, Cause(err))
+
+				// the cause is kept, and classes accumulate
+				err = Wrap(err, WithCause(io.ErrClosedPipe), Classify(io.ErrShortWrite), Classify(nil))
+				assert.ErrorIs(t, err, io.EOF)
+				assert.ErrorIs(t, err, io.ErrShortWrite)
+				assert.ErrorIs(t, err, io.ErrClosedPipe)
+				assert.Equal(t, io.ErrClosedPipe, Cause(err))
+
+				// sentinels' values aren't added
+				err = Wrap(err, Classify(Sentinel("not found", WithHTTPCode(404))))
+				assert.Equal(t, 500, HTTPCode(err))
+			},
+		},
+		{
+			name:    "Downgrade",
+			wrapper: Downgrade(),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "bang")
+				assert.Equal(t, "warning", Severity(err))
+				assert.Equal(t, 500, HTTPCode(err))
+				assert.Equal(t, 404, HTTPCode(Wrap(err, WithHTTPCode(404), Downgrade())))
+				assert.False(t, IsFatal(err))
+				assert.True(t, IsFatal(New("bang", WithCause(err))))
+				assert.False(t, IsFatal(nil))
+				assert.Contains(t, Details(err), "Severity: warning")
+			},
+		},
+		{
+			name:    "MarkLogged",
+			wrapper: MarkLogged(),
+			assertions: func(t *testing.T, err error) {
+				assert.True(t, WasLogged(err))
+				assert.True(t, WasLogged(Wrap(err, WithHTTPCode(404))))
+				assert.False(t, WasLogged(New("bang", WithCause(err))))
+			},
+		},
+		{
+			name:    "WithHint",
+			wrapper: WithHint("run `app login`"),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, "run `app login`", Hint(err))
+				assert.Contains(t, Details(err), "Hint: run `app login`")
+			},
+		},
+		{
+			name:    "WithElapsedSince",
+			wrapper: WithElapsedSince(time.Now().Add(-time.Minute)),
+			assertions: func(t *testing.T, err error) {
+				d, ok := Elapsed(err)
+				assert.True(t, ok)
+				assert.GreaterOrEqual(t, d, time.Minute)
+				assert.Contains(t, Details(err), "Elapsed: 1m")
+			},
+		},
+		{
+			name:    "Because",
+			wrapper: Because(errors.New("boom"), "big"),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "big: bang")
+				assert.EqualError(t, Cause(err), "boom")
+			},
+		},
 		{
 			name:    "AppendMessage",
 			wrapper: AppendMessage("boom"),
@@ -76,6 +209,20 @@ func TestWrappers(t *testing.T) {
 				assert.EqualError(t, err, "big boom: bang")
 			},
 		},
+		{
+			name:    "AppendMessageUnique",
+			wrapper: AppendMessageUnique("boom"),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "bang: boom")
+			},
+		},
+		{
+			name:    "PrependMessageUnique",
+			wrapper: PrependMessageUnique("boom"),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "boom: bang")
+			},
+		},
 		{
 			name:    "WithHTTPCode",
 			wrapper: WithHTTPCode(56),
@@ -90,6 +237,13 @@ func TestWrappers(t *testing.T) {
 				assert.Equal(t, []uintptr{1, 2, 3, 4, 5}, Stack(err))
 			},
 		},
+		{
+			name:    "WithStackIfAbsent",
+			wrapper: WithStackIfAbsent([]uintptr{1, 2, 3, 4, 5}),
+			assertions: func(t *testing.T, err error) {
+				assert.Equal(t, []uintptr{1, 2, 3, 4, 5}, Stack(err))
+			},
+		},
 		{
 			name:    "WithFormattedStack",
 			wrapper: WithFormattedStack([]string{"blue", "red"}),
@@ -118,6 +272,22 @@ func TestWrappers(t *testing.T) {
 				assert.EqualError(t, Cause(err), "crash")
 			},
 		},
+		{
+			name:    "WithSuppressed",
+			wrapper: WithSuppressed(errors.New("close failed"), nil),
+			assertions: func(t *testing.T, err error) {
+				require.Len(t, Suppressed(err), 1)
+				assert.EqualError(t, Suppressed(err)[0], "close failed")
+			},
+		},
+		{
+			name:    "ImportChain",
+			wrapper: ImportChain(fmt.Errorf("crash: %w", errors.New("bang"))),
+			assertions: func(t *testing.T, err error) {
+				assert.EqualError(t, Cause(err), "crash: bang")
+				assert.EqualError(t, Cause(Cause(err)), "bang")
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -130,6 +300,124 @@ func TestWrappers(t *testing.T) {
 	}
 }
 
+func TestSuppressDetailsStack(t *testing.T) {
+	cause := New("bang")
+	err := New("not found", SuppressDetailsStack(), AddStack(), WithCause(cause))
+
+	// the stack is still captured
+	assert.NotEmpty(t, Stack(err))
+	assert.NotEmpty(t, Stacktrace(err))
+
+	// but not printed
+	d := Details(err)
+	assert.NotContains(t, d, Stacktrace(err))
+	assert.NotContains(t, d, "crossed boundary")
+	assert.NotContains(t, fmt.Sprintf("%+v", err), Stacktrace(err))
+
+	// the cause's stack is still printed
+	assert.Contains(t, d, Stacktrace(cause))
+}
+
+func TestWithSuppressed(t *testing.T) {
+	closeErr := errors.New("close failed")
+	flushErr := New("flush failed")
+
+	err := New("write failed", WithSuppressed(closeErr))
+	err = Wrap(err, WithSuppressed(flushErr))
+
+	// accumulates, oldest first
+	assert.Equal(t, []error{closeErr, flushErr}, Suppressed(err))
+
+	// suppressed errors are not matched
+	assert.NotErrorIs(t, err, closeErr)
+	assert.NotErrorIs(t, err, flushErr)
+
+	// no errors -> no-op
+	base := New("boom")
+	assert.Equal(t, base, WithSuppressed(nil).Wrap(base, 0))
+	assert.Nil(t, Suppressed(base))
+
+	// printed by Details
+	d := Details(err)
+	assert.Contains(t, d, "\n\nSuppressed:\n\tclose failed\n\tflush failed\n\t")
+}
+
+func TestWithGroup(t *testing.T) {
+	err := New("boom",
+		WithValue("color", "red"),
+		WithGroup("db"),
+		WithValue("query", "select"),
+		WithHTTPCode(5),
+		WithValue("rows", 3),
+	)
+
+	// values with string keys after the group are namespaced
+	assert.Equal(t, map[string]interface{}{
+		"color": "red",
+		"db": map[string]interface{}{
+			"query": "select",
+			"rows":  3,
+		},
+	}, Fields(err))
+	assert.Nil(t, Value(err, "query"))
+	assert.Equal(t, "red", Value(err, "color"))
+
+	// other values are unaffected
+	assert.Equal(t, 5, HTTPCode(err))
+	assert.NotEmpty(t, Stack(err))
+
+	// groups only extend to the end of the call
+	err = Wrap(err, WithValue("size", 1))
+	assert.Equal(t, 1, Fields(err)["size"])
+
+	// groups can be nested
+	err = Wrap(err, WithGroup("http"), WithValue("method", "GET"), WithGroup("req"), WithValue("path", "/"), WithCause(errors.New("bam")))
+	assert.Equal(t, map[string]interface{}{
+		"method": "GET",
+		"req": map[string]interface{}{
+			"path": "/",
+		},
+	}, Fields(err)["http"])
+	assert.EqualError(t, Cause(err), "bam")
+
+	// on its own, a group does nothing
+	assert.EqualError(t, WithGroup("db").Wrap(errors.New("bang"), 0), "bang")
+	assert.Nil(t, WithGroup("db").Wrap(nil, 0))
+}
+
+func TestImportChain(t *testing.T) {
+	root := errors.New("connection refused")
+	foreign := &UnwrapperError{fmt.Errorf("dialing: %w", root)}
+	err := New("query failed", ImportChain(foreign))
+
+	// each error in the foreign chain becomes a cause
+	var causes []string
+	for c := Cause(err); c != nil; c = Cause(c) {
+		causes = append(causes, c.Error())
+	}
+	assert.Equal(t, []string{"dialing: connection refused", "dialing: connection refused", "connection refused"}, causes)
+	assert.Contains(t, Details(err), "Caused By: connection refused")
+	// each cause is printed once
+	assert.Equal(t, 3, strings.Count(Details(err), "Caused By:"))
+
+	// Is and As still work
+	assert.ErrorIs(t, err, root)
+	assert.ErrorIs(t, err, foreign)
+	var uerr *UnwrapperError
+	assert.ErrorAs(t, err, &uerr)
+	assert.Equal(t, foreign, uerr)
+
+	// importing stops at merry errors
+	merr := New("bam", WithCause(root))
+	err = New("boom", ImportChain(fmt.Errorf("wrapped: %w", merr)))
+	assert.Equal(t, merr, Cause(Cause(err)))
+	assert.Equal(t, 3, strings.Count(Details(err), "Caused By:"))
+	assert.Equal(t, 1, strings.Count(Details(err), "Caused By: bam"))
+
+	// nil cause is a no-op
+	assert.Nil(t, Cause(New("boom", ImportChain(nil))))
+}
+
 func TestSet(t *testing.T) {
 	// nil -> nil
 	assert.Nil(t, Set(nil, "color", "red"))
@@ -155,6 +443,424 @@ func TestNoCaptureStack(t *testing.T) {
 	// should also work when wrapping an external error
 	err = Wrap(errors.New("bang"), NoCaptureStack())
 	assert.Nil(t, Stack(err))
+
+	// only the errors it is applied to are affected
+	assert.NotEmpty(t, Stack(New("bang")))
+}
+
+func TestQuickStack(t *testing.T) {
+	defer SetStackCaptureEnabled(true)
+
+	var recurse func(n int) error
+	recurse = func(n int) error {
+		if n == 0 {
+			return New("bang", QuickStack())
+		}
+		return recurse(n - 1)
+	}
+
+	err := recurse(20)
+	assert.Len(t, Stack(err), QuickStackDepth)
+	frame, _ := runtime.CallersFrames(Stack(err)[:1]).Next()
+	assert.Contains(t, frame.Function, "TestQuickStack.func1")
+
+	// existing stacks are kept
+	err = New("bang")
+	assert.Equal(t, Stack(err), Stack(Wrap(err, QuickStack())))
+
+	// if global capture disabled, it won't capture a stack
+	SetStackCaptureEnabled(false)
+	assert.Nil(t, Stack(New("bang", QuickStack())))
+}
+
+func BenchmarkQuickStack(b *testing.B) {
+	// create the errors from deep in the stack, where capture is most expensive
+	var recurse func(n int, wrappers ...Wrapper) error
+	recurse = func(n int, wrappers ...Wrapper) error {
+		if n == 0 {
+			return New("boom", wrappers...)
+		}
+		return recurse(n-1, wrappers...)
+	}
+
+	b.Run("default", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = recurse(50)
+		}
+	})
+	b.Run("quick", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = recurse(50, QuickStack())
+		}
+	})
+}
+
+func TestWithMaxStackDepth(t *testing.T) {
+	defer SetMaxStackDepth(MaxStackDepth())
+
+	// shallower than the global depth
+	err := New("boom", WithMaxStackDepth(2))
+	assert.Len(t, Stack(err), 2)
+	assert.Len(t, FormattedStack(err), 3)
+	assert.Equal(t, "github.com/ansel1/merry/v2.TestWithMaxStackDepth", Frames(err)[0].Function)
+
+	// deeper than the global depth
+	SetMaxStackDepth(1)
+	assert.Len(t, Stack(New("boom")), 1)
+	assert.Greater(t, len(Stack(New("boom", WithMaxStackDepth(50)))), 2)
+
+	// applies to CaptureStack too
+	assert.Len(t, Stack(Wrap(err, WithMaxStackDepth(1), CaptureStack(true))), 1)
+}
+
+func TestLocationOnly(t *testing.T) {
+	defer SetStackCaptureEnabled(true)
+
+	_, _, line, _ := runtime.Caller(0)
+	err := New("bang", LocationOnly())
+	assert.Len(t, Stack(err), 1)
+
+	file, l := Location(err)
+	assert.Equal(t, "wrappers_test.go", path.Base(file))
+	assert.Equal(t, line+1, l)
+	assert.Equal(t, fmt.Sprintf("github.com/ansel1/merry/v2.TestLocationOnly (wrappers_test.go:%d)", line+1), SourceLine(err))
+	assert.Len(t, strings.Split(Stacktrace(err), "\n"), 2)
+
+	// existing stacks are kept
+	err = New("bang")
+	assert.Equal(t, Stack(err), Stack(Wrap(err, LocationOnly())))
+
+	// if global capture disabled, it won't capture a stack
+	SetStackCaptureEnabled(false)
+	assert.Nil(t, Stack(New("bang", LocationOnly())))
+}
+
+func TestWithCaller(t *testing.T) {
+	defer SetStackCaptureEnabled(true)
+	SetStackCaptureEnabled(false)
+
+	_, _, line, _ := runtime.Caller(0)
+	err := New("bang", WithCaller())
+	assert.Empty(t, Stack(err))
+
+	caller := fmt.Sprintf("github.com/ansel1/merry/v2.TestWithCaller (wrappers_test.go:%d)", line+1)
+	assert.Equal(t, caller, Caller(err))
+	assert.Contains(t, Details(err), "Caller: "+caller)
+	assert.Equal(t, caller, RegisteredDetails(err)["Caller"])
+
+	assert.Empty(t, Caller(New("bang")))
+	assert.Nil(t, WithCaller().Wrap(nil, 0))
+}
+
+func TestWithSourceInMessage(t *testing.T) {
+	defer SetStackCaptureEnabled(true)
+	SetStackCaptureEnabled(false)
+
+	_, _, line, _ := runtime.Caller(0)
+	err := New("bang", WithSourceInMessage())
+	assert.EqualError(t, err, fmt.Sprintf("bang (wrappers_test.go:%d)", line+1))
+	assert.Equal(t, "bang", Message(err))
+
+	err = Prepend(err, "wrapped")
+	assert.EqualError(t, err, fmt.Sprintf("wrapped: bang (wrappers_test.go:%d)", line+1))
+
+	assert.Nil(t, WithSourceInMessage().Wrap(nil, 0))
+}
+
+func TestCaptureStackFrom(t *testing.T) {
+	pc, _, line, _ := runtime.Caller(0)
+
+	err := New("bang", CaptureStackFrom(pc))
+	assert.Equal(t, []uintptr{pc}, Stack(err))
+	file, l := Location(err)
+	assert.Equal(t, "wrappers_test.go", path.Base(file))
+	assert.Equal(t, line, l)
+
+	// replaces existing stacks
+	err = Wrap(New("bang"), CaptureStackFrom(pc))
+	assert.Equal(t, []uintptr{pc}, Stack(err))
+
+	// 0 is ignored
+	err = New("bang")
+	assert.Equal(t, Stack(err), Stack(Wrap(err, CaptureStackFrom(0))))
+}
+
+func TestCaptureStackHeadTail(t *testing.T) {
+	defer SetStackCaptureEnabled(true)
+
+	var recurse func(n int) error
+	recurse = func(n int) error {
+		if n == 0 {
+			return New("bang", CaptureStackHeadTail(3, 2))
+		}
+		return recurse(n - 1)
+	}
+
+	_, _, rl, _ := runtime.Caller(0)
+	err := recurse(100)
+
+	// the marker is only printed, it isn't part of the stack
+	s := Stack(err)
+	assert.Len(t, s, 5)
+	assert.NotContains(t, s, elidedFrames)
+	assert.Equal(t, s, Values(err)[errKeyStack])
+
+	lines := FormattedStack(err)
+	assert.Len(t, lines, 6)
+	assert.Contains(t, lines[0], "TestCaptureStackHeadTail.func1")
+	assert.Equal(t, "...\n\t(frames elided)", lines[3])
+	assert.Contains(t, lines[5], "runtime.goexit")
+	assert.Contains(t, Stacktrace(err), "\n...\n\t(frames elided)\n")
+
+	// location is still the call site
+	_, l := Location(err)
+	assert.Equal(t, rl-5, l)
+
+	// short stacks are kept whole
+	err = New("bang", CaptureStackHeadTail(100, 100))
+	assert.NotContains(t, Stack(err), elidedFrames)
+
+	// if global capture disabled, it won't capture a stack
+	SetStackCaptureEnabled(false)
+	assert.Nil(t, Stack(New("bang", CaptureStackHeadTail(3, 2))))
+}
+
+func TestAddStack(t *testing.T) {
+	defer SetStackCaptureEnabled(true)
+
+	origin := New("bang")
+	origStack := Stack(origin)
+
+	boundary := func() error {
+		return Wrap(origin, AddStack())
+	}
+
+	_, _, rl, _ := runtime.Caller(0)
+	err := boundary()
+
+	// the original stack is kept
+	assert.Equal(t, origStack, Stack(err))
+
+	stacks := Stacks(err)
+	require.Len(t, stacks, 2)
+	assert.Equal(t, origStack, stacks[1])
+
+	frame, _ := runtime.CallersFrames(stacks[0][:1]).Next()
+	assert.Contains(t, frame.Function, "TestAddStack.func1")
+	assert.Equal(t, rl-3, frame.Line)
+
+	// the boundary stack is printed after the original stack
+	d := Details(err)
+	assert.Contains(t, d, "\n\ncrossed boundary at:\n"+strings.Join(formatStack(stacks[0]), "\n"))
+	assert.Less(t, strings.Index(d, Stacktrace(err)), strings.Index(d, "crossed boundary at:"))
+	assert.NotContains(t, DetailsStable(err, OmitStack), "crossed boundary at:")
+
+	// if global capture disabled, it won't capture a stack
+	SetStackCaptureEnabled(false)
+	assert.Len(t, Stacks(Wrap(origin, AddStack())), 1)
+}
+
+func TestWithBuildInfo(t *testing.T) {
+	// test binaries usually don't have a version, so set one
+	buildVersion()
+	defer func(v string) { buildVersionValue = v }(buildVersionValue)
+	buildVersionValue = "v1.2.3"
+
+	err := WithBuildInfo().Wrap(errors.New("bang"), 0)
+	assert.Equal(t, "v1.2.3", Build(err))
+	assert.Contains(t, Details(err), "Build: v1.2.3")
+	assert.Empty(t, Build(New("bang")))
+
+	// the version isn't replaced
+	buildVersionValue = "v1.2.4"
+	assert.Equal(t, "v1.2.3", Build(WithBuildInfo().Wrap(err, 0)))
+
+	// without build information, it's a no-op
+	buildVersionValue = ""
+	assert.False(t, HasValue(WithBuildInfo().Wrap(errors.New("bang"), 0), errKeyBuild))
+}
+
+func TestVersionOf(t *testing.T) {
+	info := &debug.BuildInfo{}
+	info.Main.Version = "v1.2.3"
+	assert.Equal(t, "v1.2.3", versionOf(info))
+
+	info.Main.Version = "(devel)"
+	assert.Equal(t, "", versionOf(info))
+
+	info.Settings = []debug.BuildSetting{
+		{Key: "vcs.revision", Value: "0123456789abcdef"},
+		{Key: "vcs.modified", Value: "false"},
+	}
+	assert.Equal(t, "0123456789ab", versionOf(info))
+
+	info.Settings[1].Value = "true"
+	assert.Equal(t, "0123456789ab-dirty", versionOf(info))
+}
+
+func TestAutoHTTPCode(t *testing.T) {
+	defer Snapshot().Restore()
+	ClearHooks()
+	AddHooks(AutoHTTPCode())
+
+	assert.Equal(t, 504, HTTPCode(Wrap(context.DeadlineExceeded)))
+	assert.Equal(t, 499, HTTPCode(Wrap(context.Canceled)))
+	assert.Equal(t, 404, HTTPCode(Wrap(sql.ErrNoRows)))
+
+	_, err := os.Open("/does/not/exist")
+	assert.Equal(t, 404, HTTPCode(Wrap(err)))
+
+	// causes are matched too, including causes attached by the same call
+	assert.Equal(t, 404, HTTPCode(New("load failed", WithCause(sql.ErrNoRows))))
+	assert.Equal(t, 504, HTTPCode(Wrap(errors.New("boom"), WithCause(context.DeadlineExceeded))))
+	assert.Equal(t, 504, HTTPCodeDeep(Wrap(errors.New("boom"), WithCause(context.DeadlineExceeded))))
+	code, ok := AttachedHTTPCode(Wrap(errors.New("boom"), WithCause(context.DeadlineExceeded)))
+	assert.True(t, ok)
+	assert.Equal(t, 504, code)
+	assert.Contains(t, Details(Wrap(errors.New("boom"), WithCause(context.DeadlineExceeded))), "HTTP Code: 504")
+
+	// explicit codes take precedence
+	assert.Equal(t, 400, HTTPCode(Wrap(sql.ErrNoRows, WithHTTPCode(400))))
+	assert.Equal(t, 400, HTTPCode(Wrap(Wrap(sql.ErrNoRows), WithHTTPCode(400))))
+
+	// unrecognized errors are left alone
+	assert.Equal(t, 500, HTTPCode(Wrap(errors.New("boom"))))
+	_, ok = AttachedHTTPCode(Wrap(errors.New("boom")))
+	assert.False(t, ok)
+	assert.NotContains(t, Details(Wrap(errors.New("boom"))), "HTTP Code")
+
+	// extensible
+	errQuota := errors.New("quota exceeded")
+	RegisterHTTPCode(errQuota, 429)
+	assert.Equal(t, 429, HTTPCode(Wrap(errQuota)))
+	RegisterHTTPCode(errQuota, 503)
+	assert.Equal(t, 503, HTTPCode(Wrap(errQuota)))
+
+	// non-comparable targets don't panic
+	assert.NotPanics(t, func() {
+		RegisterHTTPCode(nonComparableError{}, 418)
+		RegisterHTTPCode(nonComparableError{}, 418)
+		Wrap(errors.New("boom"))
+	})
+}
+
+func TestRecordLayer(t *testing.T) {
+	ClearHooks()
+	defer ClearHooks()
+	defer func() {
+		layers = map[string]string{}
+	}()
+
+	AddOnceHooks(RecordLayer())
+
+	// no layers registered
+	assert.Empty(t, Layer(New("boom")))
+
+	RegisterLayer("github.com/ansel1", "vendor")
+	RegisterLayer("github.com/ansel1/merry/v2/", "core")
+	RegisterLayer("testing", "test runner")
+
+	// the longest matching prefix wins
+	err := New("boom")
+	assert.Equal(t, "core", Layer(err))
+	assert.Contains(t, Details(err), "Layer: core")
+
+	// the layer of an error's existing stack is used
+	err = Wrap(errors.New("boom"), WithStack(Stack(err)))
+	assert.Equal(t, "core", Layer(err))
+
+	// the first recorded layer is kept
+	assert.Equal(t, "core", Layer(WrapSkipping(err, 1)))
+
+	// frames without a registered layer are skipped
+	delete(layers, "github.com/ansel1/merry/v2")
+	assert.Equal(t, "vendor", Layer(New("boom")))
+	delete(layers, "github.com/ansel1")
+	assert.Equal(t, "test runner", Layer(New("boom")))
+}
+
+func TestTap(t *testing.T) {
+	var tapped []error
+	tap := Tap(func(err error) {
+		tapped = append(tapped, err)
+	})
+
+	err := New("bang", WithHTTPCode(404), tap, WithMessage("boom"))
+	assert.EqualError(t, err, "boom")
+	require.Len(t, tapped, 1)
+	assert.EqualError(t, tapped[0], "bang")
+	assert.Equal(t, 404, HTTPCode(tapped[0]))
+
+	// not called for nil errors
+	assert.Nil(t, Wrap(nil, tap))
+	assert.Len(t, tapped, 1)
+}
+
+func TestValueKey(t *testing.T) {
+	userID := NewValueKey[string]("userID")
+	assert.Equal(t, "userID", userID.String())
+
+	_, ok := userID.From(nil)
+	assert.False(t, ok)
+
+	err := New("boom", userID.With("u123"))
+	id, ok := userID.From(err)
+	assert.True(t, ok)
+	assert.Equal(t, "u123", id)
+	assert.Equal(t, "u123", Value(err, userID))
+
+	// keys with the same name are distinct
+	_, ok = NewValueKey[string]("userID").From(err)
+	assert.False(t, ok)
+}
+
+func TestWithNamedStack(t *testing.T) {
+	capture := func() []uintptr {
+		s := make([]uintptr, 50)
+		return s[:runtime.Callers(2, s)]
+	}
+	created, dispatched := capture(), capture()
+
+	err := New("boom", WithNamedStack("created at", created))
+	err = Wrap(err, WithNamedStack("dispatched at", dispatched))
+	stack := Stack(err)
+
+	assert.Equal(t, map[string][]uintptr{"created at": created, "dispatched at": dispatched}, NamedStacks(err))
+	assert.Equal(t, [][]uintptr{dispatched, stack, created}, Stacks(err))
+
+	// the default stack is unaffected
+	assert.NotEqual(t, created, stack)
+
+	// a label holds one stack
+	err = Wrap(err, WithNamedStack("created at", stack))
+	assert.Equal(t, stack, NamedStacks(err)["created at"])
+
+	d := Details(err)
+	assert.Contains(t, d, "\n\ncreated at:\n"+strings.Join(formatStack(stack), "\n"))
+	assert.Contains(t, d, "\n\ndispatched at:\n"+strings.Join(formatStack(dispatched), "\n"))
+	assert.Less(t, strings.Index(d, "created at:"), strings.Index(d, "dispatched at:"))
+
+	assert.Nil(t, NamedStacks(nil))
+	assert.Nil(t, NamedStacks(New("boom")))
+}
+
+func TestWithStackIfAbsent(t *testing.T) {
+	// if the error has no stack, the stack is attached
+	err := Wrap(errors.New("bang"), WithStackIfAbsent([]uintptr{1, 2, 3}))
+	assert.Equal(t, []uintptr{1, 2, 3}, Stack(err))
+
+	// an existing stack is not replaced
+	err = New("bang")
+	stack := Stack(err)
+	err = Wrap(err, WithStackIfAbsent([]uintptr{1, 2, 3}))
+	assert.Equal(t, stack, Stack(err))
+
+	// suppressed stacks count as present
+	err = New("bang", NoCaptureStack())
+	err = Wrap(err, WithStackIfAbsent([]uintptr{1, 2, 3}))
+	assert.Nil(t, Stack(err))
 }
 
 func TestCaptureStack(t *testing.T) {